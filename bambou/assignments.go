@@ -0,0 +1,187 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// assignmentRetries is the number of times AddAssignments and RemoveAssignments
+// retry when the assignment conflicts with another writer.
+const assignmentRetries = 5
+
+// assignmentBackoff is the delay before the first retry of AddAssignments and RemoveAssignments.
+// It is doubled before each following retry.
+const assignmentBackoff = 10 * time.Millisecond
+
+// AddAssignments assigns the given children with the given Identity to the given parent,
+// keeping the children already assigned. The current assignments are fetched before
+// assigning the new list, then fetched again to verify that the children are assigned.
+// The whole operation is retried, with an increasing delay, if the server reports a conflict
+// or if the children are not assigned anymore. It returns the identifiers of the children
+// that were not already assigned.
+//
+// The server cannot reject a list computed from outdated assignments, so an update can
+// still be lost: if another writer fetches the assignments before this assignment and
+// assigns its own list after the verification, the children assigned here are unassigned
+// and no error is returned to either writer.
+func AddAssignments(storer Storer, parent Identifiable, children []Identifiable, identity Identity) ([]string, *Error) {
+
+	return updateAssignments(storer, parent, children, identity, func(assigned map[string]bool, id string) bool {
+		return !assigned[id]
+	})
+}
+
+// RemoveAssignments unassigns the given children with the given Identity from the given parent,
+// keeping the other children assigned. The current assignments are fetched before
+// assigning the new list, then fetched again to verify that the children are unassigned.
+// The whole operation is retried, with an increasing delay, if the server reports a conflict
+// or if the children are assigned again. It returns the identifiers of the children that
+// were actually assigned. It has the same lost update window as AddAssignments.
+func RemoveAssignments(storer Storer, parent Identifiable, children []Identifiable, identity Identity) ([]string, *Error) {
+
+	return updateAssignments(storer, parent, children, identity, func(assigned map[string]bool, id string) bool {
+		return assigned[id]
+	})
+}
+
+// updateAssignments applies the delta of the given children to the current assignments.
+// The children for which changes returns true are added to the assignments if they are
+// not assigned, or removed from them otherwise.
+func updateAssignments(storer Storer, parent Identifiable, children []Identifiable, identity Identity, changes func(map[string]bool, string) bool) ([]string, *Error) {
+
+	ids := make([]string, len(children))
+	for i, c := range children {
+
+		if ids[i] = c.Identifier(); ids[i] == "" {
+			return nil, NewBambouError("Assignment error", "One of the object to assign has no ID")
+		}
+	}
+
+	var berr *Error
+
+	for attempt := 0; attempt <= assignmentRetries; attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(assignmentBackoff << uint(attempt-1))
+		}
+
+		current, err := fetchAllIdentifiers(storer, parent, identity)
+		if err != nil {
+			return nil, err
+		}
+
+		assigned := make(map[string]bool, len(current))
+		for _, id := range current {
+			assigned[id] = true
+		}
+
+		var changed []string
+		for _, id := range ids {
+
+			if changes(assigned, id) && !containsString(changed, id) {
+				changed = append(changed, id)
+			}
+		}
+
+		if len(changed) == 0 {
+			return nil, nil
+		}
+
+		var updated []string
+		for _, id := range current {
+
+			if !containsString(changed, id) {
+				updated = append(updated, id)
+			}
+		}
+
+		for _, id := range changed {
+
+			if !assigned[id] {
+				updated = append(updated, id)
+			}
+		}
+
+		if berr = storer.AssignChildren(parent, newIdentifiableReferences(identity, updated), identity); berr != nil {
+			if !isAssignmentConflict(berr) {
+				return nil, berr
+			}
+			continue
+		}
+
+		if berr = verifyAssignments(storer, parent, identity, assigned, changed); berr == nil {
+			return changed, nil
+		}
+
+		if !isAssignmentConflict(berr) {
+			return nil, berr
+		}
+	}
+
+	return nil, NewBambouError("Assignment error", fmt.Sprintf("Unable to assign %s to %s after %d attempts: %s", identity.Category, describeIdentifiable(parent), assignmentRetries+1, berr.Description))
+}
+
+// verifyAssignments verifies that the given changed children have been added to or removed from the
+// assignments, according to the given previous assignments. It returns a conflict error otherwise.
+func verifyAssignments(storer Storer, parent Identifiable, identity Identity, previous map[string]bool, changed []string) *Error {
+
+	current, berr := fetchAllIdentifiers(storer, parent, identity)
+	if berr != nil {
+		return berr
+	}
+
+	for _, id := range changed {
+
+		if containsString(current, id) == previous[id] {
+			berr := NewBambouError("Assignment error", fmt.Sprintf("The %s assigned to %s have been modified by someone else", identity.Category, describeIdentifiable(parent)))
+			berr.Code = http.StatusPreconditionFailed
+			return berr
+		}
+	}
+
+	return nil
+}
+
+// isAssignmentConflict returns true if the given error means that the assignments
+// have been modified by another writer. A plain 409 is not a conflict, as the
+// server also uses it for validation errors.
+func isAssignmentConflict(berr *Error) bool {
+
+	return berr.IsConflict() || berr.Code == http.StatusPreconditionFailed
+}
+
+// containsString returns true if the given slice contains the given string.
+func containsString(slice []string, s string) bool {
+
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// conflictingStorer is a MemoryStorer simulating a concurrent writer assigning other children.
+// The first assignments fail with a failed precondition, or succeed and are then overwritten.
+// The first rejections fail with a validation error.
+type conflictingStorer struct {
+	*MemoryStorer
+	conflicts  int
+	overwrites int
+	rejections int
+	assigns    int
+}
+
+func (s *conflictingStorer) AssignChildren(parent Identifiable, children []Identifiable, identity Identity) *Error {
+
+	s.assigns++
+
+	other := []Identifiable{NewFakeObject("a1"), NewFakeObject("other")}
+
+	if s.rejections > 0 {
+		s.rejections--
+		return NewError(http.StatusConflict, "invalid")
+	}
+
+	if s.conflicts > 0 {
+		s.conflicts--
+		s.MemoryStorer.AssignChildren(parent, other, identity)
+		return NewError(http.StatusPreconditionFailed, "modified")
+	}
+
+	if berr := s.MemoryStorer.AssignChildren(parent, children, identity); berr != nil {
+		return berr
+	}
+
+	if s.overwrites > 0 {
+		s.overwrites--
+		s.MemoryStorer.AssignChildren(parent, other, identity)
+	}
+
+	return nil
+}
+
+func assignedIdentifiers(s Storer, parent Identifiable) []string {
+
+	ids, _ := fetchAllIdentifiers(s, parent, FakeIdentity)
+	return ids
+}
+
+func TestAssignments_AddAssignments(t *testing.T) {
+
+	Convey("Given I have a parent with an assigned child", t, func() {
+
+		r := NewFakeRootObject()
+		s := &conflictingStorer{MemoryStorer: NewMemoryStorer(r)}
+
+		parent := NewFakeObject("parent")
+		s.CreateChild(r, parent)
+		for _, id := range []string{"a1", "a2", "a3", "other"} {
+			s.CreateChild(r, NewFakeObject(id))
+		}
+		s.MemoryStorer.AssignChildren(parent, []Identifiable{NewFakeObject("a1")}, FakeIdentity)
+
+		Convey("When I add assignments", func() {
+
+			changed, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a1"), NewFakeObject("a2"), NewFakeObject("a3")}, FakeIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then only the new children should be reported", func() {
+				So(changed, ShouldResemble, []string{"a2", "a3"})
+			})
+
+			Convey("Then all the children should be assigned", func() {
+				So(assignedIdentifiers(s, parent), ShouldResemble, []string{"a1", "a2", "a3"})
+			})
+		})
+
+		Convey("When I add assignments that already exist", func() {
+
+			changed, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a1")}, FakeIdentity)
+
+			Convey("Then nothing should be assigned", func() {
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeEmpty)
+				So(s.assigns, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I add assignments while another writer modifies them", func() {
+
+			s.conflicts = 1
+			changed, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a2")}, FakeIdentity)
+
+			Convey("Then the assignment should be retried", func() {
+				So(err, ShouldBeNil)
+				So(changed, ShouldResemble, []string{"a2"})
+				So(s.assigns, ShouldEqual, 2)
+			})
+
+			Convey("Then the assignments of the other writer should be kept", func() {
+				So(assignedIdentifiers(s, parent), ShouldResemble, []string{"a1", "other", "a2"})
+			})
+		})
+
+		Convey("When another writer overwrites the assignments after mine", func() {
+
+			s.overwrites = 1
+			changed, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a2")}, FakeIdentity)
+
+			Convey("Then the assignment should be retried", func() {
+				So(err, ShouldBeNil)
+				So(changed, ShouldResemble, []string{"a2"})
+				So(s.assigns, ShouldEqual, 2)
+			})
+
+			Convey("Then both assignments should be kept", func() {
+				So(assignedIdentifiers(s, parent), ShouldResemble, []string{"a1", "other", "a2"})
+			})
+		})
+
+		Convey("When the server rejects the assignment", func() {
+
+			s.rejections = 1
+			_, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a2")}, FakeIdentity)
+
+			Convey("Then the assignment should not be retried", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusConflict)
+				So(s.assigns, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the assignment keeps conflicting", func() {
+
+			s.conflicts = assignmentRetries + 1
+			_, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("a2")}, FakeIdentity)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Assignment error")
+				So(s.assigns, ShouldEqual, assignmentRetries+1)
+			})
+		})
+
+		Convey("When I add a child without ID", func() {
+
+			_, err := AddAssignments(s, parent, []Identifiable{NewFakeObject("")}, FakeIdentity)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(s.assigns, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestAssignments_RemoveAssignments(t *testing.T) {
+
+	Convey("Given I have a parent with assigned children", t, func() {
+
+		r := NewFakeRootObject()
+		s := &conflictingStorer{MemoryStorer: NewMemoryStorer(r)}
+
+		parent := NewFakeObject("parent")
+		s.CreateChild(r, parent)
+		for _, id := range []string{"a1", "a2", "a3"} {
+			s.CreateChild(r, NewFakeObject(id))
+		}
+		s.MemoryStorer.AssignChildren(parent, []Identifiable{NewFakeObject("a1"), NewFakeObject("a2"), NewFakeObject("a3")}, FakeIdentity)
+
+		Convey("When I remove assignments", func() {
+
+			changed, err := RemoveAssignments(s, parent, []Identifiable{NewFakeObject("a2"), NewFakeObject("unknown")}, FakeIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then only the assigned children should be reported", func() {
+				So(changed, ShouldResemble, []string{"a2"})
+			})
+
+			Convey("Then the other children should still be assigned", func() {
+				So(assignedIdentifiers(s, parent), ShouldResemble, []string{"a1", "a3"})
+			})
+		})
+
+		Convey("When I remove children that are not assigned", func() {
+
+			changed, err := RemoveAssignments(s, parent, []Identifiable{NewFakeObject("unknown")}, FakeIdentity)
+
+			Convey("Then nothing should be assigned", func() {
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeEmpty)
+				So(s.assigns, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestAssignments_RemoveLastAssignment(t *testing.T) {
+
+	Convey("Given I have a server with a parent that has one assigned child", t, func() {
+
+		var lock sync.Mutex
+		var bodies []string
+		assigned := []string{"a1"}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if r.Method == "PUT" {
+				data, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(data))
+				json.Unmarshal(data, &assigned)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			objects := []*FakeObject{}
+			for _, id := range assigned {
+				objects = append(objects, NewFakeObject(id))
+			}
+			data, _ := json.Marshal(objects)
+			fmt.Fprint(w, string(data))
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+
+		Convey("When I remove the assigned child", func() {
+
+			changed, err := RemoveAssignments(session, NewFakeObject("parent"), []Identifiable{NewFakeObject("a1")}, FakeIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(changed, ShouldResemble, []string{"a1"})
+			})
+
+			Convey("Then an empty list should have been assigned", func() {
+				So(bodies, ShouldResemble, []string{"[]\n"})
+				So(assigned, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import "sync"
+
+// defaultBulkConcurrency is the number of concurrent operations used when BulkOptions.Concurrency is not set.
+const defaultBulkConcurrency = 10
+
+// BulkOptions represents the options of a bulk operation.
+type BulkOptions struct {
+
+	// Concurrency is the maximum number of operations running at the same time.
+	// If it is zero, 10 operations are run at the same time.
+	Concurrency int
+
+	// ContinueOnError continues with the remaining objects when an operation fails.
+	// Otherwise, the operations not started yet are skipped.
+	ContinueOnError bool
+}
+
+// BulkResult represents the result of a bulk operation for one Identifiable.
+type BulkResult struct {
+	Object  Identifiable
+	Error   *Error
+	Skipped bool
+}
+
+// BulkResults represents a list of *BulkResult.
+type BulkResults []*BulkResult
+
+// HasErrors returns true if at least one operation has failed or has been skipped.
+func (r BulkResults) HasErrors() bool {
+
+	for _, result := range r {
+		if result.Error != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Failed returns the results of the operations that have failed or have been skipped.
+func (r BulkResults) Failed() BulkResults {
+
+	failed := BulkResults{}
+
+	for _, result := range r {
+		if result.Error != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+// BulkCreateChildren creates the given children under the given parent.
+// The results are in the same order as the children.
+func (s *Session) BulkCreateChildren(parent Identifiable, children []Identifiable, options BulkOptions) BulkResults {
+
+	return runBulk(children, options, func(child Identifiable) *Error {
+		return s.CreateChild(parent, child)
+	})
+}
+
+// BulkSave saves the given objects.
+// The results are in the same order as the objects.
+func (s *Session) BulkSave(objects []Identifiable, options BulkOptions) BulkResults {
+
+	return runBulk(objects, options, s.SaveEntity)
+}
+
+// BulkDelete deletes the given objects.
+// The results are in the same order as the objects.
+func (s *Session) BulkDelete(objects []Identifiable, options BulkOptions) BulkResults {
+
+	return runBulk(objects, options, s.DeleteEntity)
+}
+
+// runBulk calls the given operation for each given object according to the given BulkOptions.
+func runBulk(objects []Identifiable, options BulkOptions, operation func(Identifiable) *Error) BulkResults {
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+
+	results := make(BulkResults, len(objects))
+	for i, object := range objects {
+		results[i] = &BulkResult{Object: object}
+	}
+
+	indexes := make(chan int)
+	stop := make(chan struct{})
+	stopOnce := &sync.Once{}
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < concurrency && i < len(objects); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if berr := operation(objects[index]); berr != nil {
+					results[index].Error = berr
+					if !options.ContinueOnError {
+						stopOnce.Do(func() { close(stop) })
+					}
+				}
+			}
+		}()
+	}
+
+	next := 0
+
+dispatch:
+	for ; next < len(objects); next++ {
+		select {
+		case <-stop:
+			break dispatch
+		default:
+		}
+
+		select {
+		case indexes <- next:
+		case <-stop:
+			break dispatch
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	for ; next < len(objects); next++ {
+		results[next].Error = NewBambouError("Bulk error", "The operation has been skipped after a previous error")
+		results[next].Skipped = true
+	}
+
+	return results
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newBulkServer returns a server failing the requests for the object named bad,
+// and recording the maximum number of concurrent requests.
+func newBulkServer(maxConcurrent *int) *httptest.Server {
+
+	lock := &sync.Mutex{}
+	current := 0
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		lock.Lock()
+		current++
+		if current > *maxConcurrent {
+			*maxConcurrent = current
+		}
+		lock.Unlock()
+
+		defer func() {
+			lock.Lock()
+			current--
+			lock.Unlock()
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+
+		o := &FakeObject{}
+		json.NewDecoder(r.Body).Decode(o)
+
+		if o.Name == "bad" || strings.HasSuffix(r.URL.Path, "/bad") {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"errors": [{"property": "name", "descriptions": [{"title": "bad", "description": "bad object"}]}]}`)
+			return
+		}
+
+		if o.ID == "" {
+			o.ID = o.Name
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]*FakeObject{o})
+	}))
+}
+
+// newBulkObjects returns FakeObjects with the given names.
+func newBulkObjects(names ...string) []Identifiable {
+
+	objects := make([]Identifiable, len(names))
+	for i, name := range names {
+		o := NewFakeObject("")
+		o.Name = name
+		objects[i] = o
+	}
+
+	return objects
+}
+
+func TestBulk_BulkCreateChildren(t *testing.T) {
+
+	Convey("Given I have a session and a server", t, func() {
+
+		maxConcurrent := 0
+		ts := newBulkServer(&maxConcurrent)
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+
+		Convey("When I create 20 children with a concurrency of 3", func() {
+
+			var names []string
+			for i := 0; i < 20; i++ {
+				names = append(names, fmt.Sprintf("o%d", i))
+			}
+
+			results := session.BulkCreateChildren(session.Root(), newBulkObjects(names...), BulkOptions{Concurrency: 3})
+
+			Convey("Then there should be no error", func() {
+				So(results.HasErrors(), ShouldBeFalse)
+				So(len(results.Failed()), ShouldEqual, 0)
+			})
+
+			Convey("Then the results should be in order and the children created", func() {
+				So(len(results), ShouldEqual, 20)
+				So(results[5].Object.Identifier(), ShouldEqual, "o5")
+			})
+
+			Convey("Then at most 3 requests should have been sent at the same time", func() {
+				So(maxConcurrent, ShouldBeBetweenOrEqual, 2, 3)
+			})
+		})
+
+		Convey("When I create children with an error and continue on error", func() {
+
+			results := session.BulkCreateChildren(session.Root(), newBulkObjects("a", "bad", "c"), BulkOptions{Concurrency: 1, ContinueOnError: true})
+
+			Convey("Then only the bad child should have failed", func() {
+				So(results.HasErrors(), ShouldBeTrue)
+				So(results[0].Error, ShouldBeNil)
+				So(results[1].Error.Code, ShouldEqual, http.StatusConflict)
+				So(results[2].Error, ShouldBeNil)
+			})
+		})
+
+		Convey("When I create children with an error and stop on error", func() {
+
+			results := session.BulkCreateChildren(session.Root(), newBulkObjects("a", "bad", "c", "d"), BulkOptions{Concurrency: 1})
+
+			Convey("Then the bad child should have failed", func() {
+				So(results[0].Error, ShouldBeNil)
+				So(results[1].Error, ShouldNotBeNil)
+				So(results[1].Skipped, ShouldBeFalse)
+			})
+
+			Convey("Then the following children should have been skipped", func() {
+				So(results[3].Skipped, ShouldBeTrue)
+				So(results[3].Error, ShouldNotBeNil)
+				So(len(results.Failed()), ShouldBeGreaterThanOrEqualTo, 2)
+			})
+		})
+	})
+}
+
+func TestBulk_BulkSaveAndDelete(t *testing.T) {
+
+	Convey("Given I have a session, a server and some objects", t, func() {
+
+		maxConcurrent := 0
+		ts := newBulkServer(&maxConcurrent)
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+
+		objects := []Identifiable{NewFakeObject("a"), NewFakeObject("bad"), NewFakeObject("c")}
+
+		Convey("When I save them", func() {
+
+			results := session.BulkSave(objects, BulkOptions{ContinueOnError: true})
+
+			Convey("Then only the bad object should have failed", func() {
+				So(len(results.Failed()), ShouldEqual, 1)
+				So(results.Failed()[0].Object, ShouldEqual, objects[1])
+			})
+		})
+
+		Convey("When I delete them", func() {
+
+			results := session.BulkDelete(objects, BulkOptions{ContinueOnError: true})
+
+			Convey("Then only the bad object should have failed", func() {
+				So(len(results.Failed()), ShouldEqual, 1)
+				So(results.Failed()[0].Object.Identifier(), ShouldEqual, "bad")
+			})
+		})
+	})
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry represents a cached response of a Storer.
+type cacheEntry struct {
+	key      string
+	identity string
+	parent   string
+	data     []byte
+	info     *FetchingInfo
+	expires  time.Time
+}
+
+// CachingStorer is a Storer serving FetchEntity and FetchChildren from a cache
+// and sending the other calls to the Storer it decorates.
+//
+// Entries expire after the TTL, and the least recently used entries are evicted
+// when there are more than MaxEntries. Entries are invalidated when they are
+// modified through the CachingStorer, and when the PushCenter given to
+// InvalidateOn receives an event about them. FetchEntityFresh and
+// FetchChildrenFresh always use the decorated Storer.
+type CachingStorer struct {
+
+	// TTL is the duration an entry is kept. Zero keeps the entries until they are invalidated.
+	TTL time.Duration
+
+	// MaxEntries is the maximum number of entries. Zero means no limit.
+	MaxEntries int
+
+	storer     Storer
+	entries    map[string]*list.Element
+	lru        *list.List
+	generation int
+	lock       sync.Mutex
+}
+
+// NewCachingStorer returns a new *CachingStorer decorating the given Storer.
+func NewCachingStorer(storer Storer, ttl time.Duration, maxEntries int) *CachingStorer {
+
+	return &CachingStorer{
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		storer:     storer,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// Storer returns the decorated Storer.
+func (c *CachingStorer) Storer() Storer {
+
+	return c.storer
+}
+
+// Start starts the decorated Storer.
+func (c *CachingStorer) Start() *Error {
+
+	return c.storer.Start()
+}
+
+// Reset resets the decorated Storer and purges the cache.
+func (c *CachingStorer) Reset() {
+
+	c.storer.Reset()
+	c.Purge()
+}
+
+// Reauthenticate authenticates the decorated Storer again, if it implements Reauthenticator.
+// The cache is kept.
+func (c *CachingStorer) Reauthenticate() *Error {
+
+	if authenticator, ok := c.storer.(Reauthenticator); ok {
+		return authenticator.Reauthenticate()
+	}
+
+	return nil
+}
+
+// Root returns the root object of the decorated Storer.
+func (c *CachingStorer) Root() Rootable {
+
+	return c.storer.Root()
+}
+
+// FetchEntity fetches the given Identifiable from the cache, or from the decorated Storer.
+func (c *CachingStorer) FetchEntity(object Identifiable) *Error {
+
+	key := cacheEntityKey(object.Identity().Name, object.Identifier())
+
+	if entry := c.get(key); entry != nil {
+		return unmarshalCached(entry.data, object)
+	}
+
+	return c.FetchEntityFresh(object)
+}
+
+// FetchEntityFresh fetches the given Identifiable from the decorated Storer and caches it.
+func (c *CachingStorer) FetchEntityFresh(object Identifiable) *Error {
+
+	generation := c.currentGeneration()
+
+	if berr := c.storer.FetchEntity(object); berr != nil {
+		return berr
+	}
+
+	c.set(generation, &cacheEntry{
+		key:      cacheEntityKey(object.Identity().Name, object.Identifier()),
+		identity: object.Identity().Name,
+	}, object, nil)
+
+	return nil
+}
+
+// FetchChildren fetches the children with the given Identity of the given parent
+// from the cache, or from the decorated Storer.
+func (c *CachingStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	key := cacheChildrenKey(parent, identity, info)
+
+	if entry := c.get(key); entry != nil {
+
+		if info != nil && entry.info != nil {
+			*info = *entry.info
+		}
+
+		return unmarshalCached(entry.data, &dest)
+	}
+
+	return c.FetchChildrenFresh(parent, identity, dest, info)
+}
+
+// FetchChildrenFresh fetches the children with the given Identity of the given parent
+// from the decorated Storer and caches them.
+func (c *CachingStorer) FetchChildrenFresh(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	generation := c.currentGeneration()
+	key := cacheChildrenKey(parent, identity, info)
+
+	if berr := c.storer.FetchChildren(parent, identity, dest, info); berr != nil {
+		return berr
+	}
+
+	var cachedInfo *FetchingInfo
+	if info != nil {
+		copied := *info
+		cachedInfo = &copied
+	}
+
+	c.set(generation, &cacheEntry{
+		key:      key,
+		identity: identity.Name,
+		parent:   cacheEntityKey(parent.Identity().Name, parent.Identifier()),
+	}, dest, cachedInfo)
+
+	return nil
+}
+
+// SaveEntity saves the given Identifiable using the decorated Storer.
+func (c *CachingStorer) SaveEntity(object Identifiable) *Error {
+
+	defer c.invalidate(object.Identity().Name, object.Identifier(), false)
+
+	return c.storer.SaveEntity(object)
+}
+
+// DeleteEntity deletes the given Identifiable using the decorated Storer.
+func (c *CachingStorer) DeleteEntity(object Identifiable) *Error {
+
+	defer c.invalidate(object.Identity().Name, object.Identifier(), true)
+
+	return c.storer.DeleteEntity(object)
+}
+
+// CreateChild creates the given child under the given parent using the decorated Storer.
+func (c *CachingStorer) CreateChild(parent Identifiable, child Identifiable) *Error {
+
+	defer c.invalidate(child.Identity().Name, "", false)
+
+	return c.storer.CreateChild(parent, child)
+}
+
+// AssignChildren assigns the given children to the given parent using the decorated Storer.
+func (c *CachingStorer) AssignChildren(parent Identifiable, children []Identifiable, identity Identity) *Error {
+
+	defer c.invalidate(identity.Name, "", false)
+
+	return c.storer.AssignChildren(parent, children, identity)
+}
+
+// NextEvent waits for the next events of the decorated Storer.
+func (c *CachingStorer) NextEvent(channel NotificationsChannel, lastEventID string) *Error {
+
+	return c.storer.NextEvent(channel, lastEventID)
+}
+
+// NextEventContext calls NextEventContext on the decorated Storer if it implements
+// CancelableEventStorer, or NextEvent otherwise.
+func (c *CachingStorer) NextEventContext(ctx context.Context, channel NotificationsChannel, lastEventID string) *Error {
+
+	if storer, ok := c.storer.(CancelableEventStorer); ok {
+		return storer.NextEventContext(ctx, channel, lastEventID)
+	}
+
+	return c.storer.NextEvent(channel, lastEventID)
+}
+
+// Invalidate removes the entries affected by the given Event.
+// The entity of the Event is removed on updates and deletions, and all the
+// cached children with the Identity of the entity are removed. The cached
+// children of a deleted entity are removed too.
+func (c *CachingStorer) Invalidate(event *Event) {
+
+	switch event.Type {
+	case EventTypeCreate:
+		c.invalidate(event.EntityType, "", false)
+	default:
+		c.invalidate(event.EntityType, event.EntityID(), event.Type == EventTypeDelete)
+	}
+}
+
+// InvalidateOn registers a handler invalidating the entries affected by the events of
+// the given PushCenter. The returned Subscription can be used to stop the invalidation.
+func (c *CachingStorer) InvalidateOn(pushCenter *PushCenter) *Subscription {
+
+	return pushCenter.RegisterHandlerForIdentity(c.Invalidate, AllIdentity)
+}
+
+// Purge removes all the entries.
+func (c *CachingStorer) Purge() {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.generation++
+}
+
+// Len returns the number of entries.
+func (c *CachingStorer) Len() int {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lru.Len()
+}
+
+// get returns the entry with the given key, if it exists and has not expired.
+func (c *CachingStorer) get(key string) *cacheEntry {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry
+}
+
+// set caches the given value in the given entry, unless entries have been
+// invalidated since the given generation.
+func (c *CachingStorer) set(generation int, entry *cacheEntry, value interface{}, info *FetchingInfo) {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	entry.data = data
+	entry.info = info
+
+	if c.TTL > 0 {
+		entry.expires = time.Now().Add(c.TTL)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate removes the entity with the given identity name and identifier, and all
+// the children with the given identity name. If children is true, the children
+// of the entity are removed too.
+func (c *CachingStorer) invalidate(name string, ID string, children bool) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+
+	entityKey := cacheEntityKey(name, ID)
+
+	for key, element := range c.entries {
+
+		entry := element.Value.(*cacheEntry)
+
+		switch {
+		case ID != "" && key == entityKey:
+		case entry.parent != "" && entry.identity == name:
+		case children && entry.parent == entityKey:
+		default:
+			continue
+		}
+
+		c.remove(element)
+	}
+}
+
+// currentGeneration returns the number of invalidations.
+func (c *CachingStorer) currentGeneration() int {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.generation
+}
+
+// remove removes the given element. The lock must be held.
+func (c *CachingStorer) remove(element *list.Element) {
+
+	delete(c.entries, element.Value.(*cacheEntry).key)
+	c.lru.Remove(element)
+}
+
+// cacheEntityKey returns the key of the entity with the given identity name and identifier.
+func cacheEntityKey(name string, ID string) string {
+
+	return name + "/" + ID
+}
+
+// cacheChildrenKey returns the key of the children with the given Identity of the given parent.
+func cacheChildrenKey(parent Identifiable, identity Identity, info *FetchingInfo) string {
+
+	key := cacheEntityKey(parent.Identity().Name, parent.Identifier()) + "|" + identity.Name
+
+	if info != nil {
+		key += fmt.Sprintf("|%d|%d|%s|%s|%s", info.Page, info.PageSize, info.Filter, info.OrderBy, strings.Join(info.GroupBy, ","))
+	}
+
+	return key
+}
+
+// unmarshalCached unmarshals the given cached data into the given destination.
+func unmarshalCached(data []byte, dest interface{}) *Error {
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingStorer is a MemoryStorer counting the fetches.
+type countingStorer struct {
+	*MemoryStorer
+	fetches int32
+}
+
+func (s *countingStorer) FetchEntity(object Identifiable) *Error {
+
+	atomic.AddInt32(&s.fetches, 1)
+	return s.MemoryStorer.FetchEntity(object)
+}
+
+func (s *countingStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	atomic.AddInt32(&s.fetches, 1)
+	return s.MemoryStorer.FetchChildren(parent, identity, dest, info)
+}
+
+func (s *countingStorer) count() int {
+
+	return int(atomic.LoadInt32(&s.fetches))
+}
+
+func TestCachingStorer_FetchEntity(t *testing.T) {
+
+	Convey("Given I have a CachingStorer with an object", t, func() {
+
+		r := NewFakeRootObject()
+		s := &countingStorer{MemoryStorer: NewMemoryStorer(r)}
+		c := NewCachingStorer(s, 0, 0)
+
+		o := NewFakeObject("x")
+		o.Name = "hello"
+		c.CreateChild(r, o)
+
+		Convey("When I fetch it twice", func() {
+
+			o1 := NewFakeObject("x")
+			o2 := NewFakeObject("x")
+			err1 := c.FetchEntity(o1)
+			err2 := c.FetchEntity(o2)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then both objects should be fetched", func() {
+				So(o1.Name, ShouldEqual, "hello")
+				So(o2.Name, ShouldEqual, "hello")
+			})
+
+			Convey("Then the decorated storer should have been called once", func() {
+				So(s.count(), ShouldEqual, 1)
+			})
+
+			Convey("When I fetch it fresh", func() {
+
+				c.FetchEntityFresh(NewFakeObject("x"))
+
+				Convey("Then the decorated storer should have been called again", func() {
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I save it through the CachingStorer and fetch it", func() {
+
+				o.Name = "updated"
+				c.SaveEntity(o)
+
+				o3 := NewFakeObject("x")
+				c.FetchEntity(o3)
+
+				Convey("Then the new value should be fetched", func() {
+					So(o3.Name, ShouldEqual, "updated")
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I delete it through the CachingStorer and fetch it", func() {
+
+				c.DeleteEntity(o)
+				err := c.FetchEntity(NewFakeObject("x"))
+
+				Convey("Then err should be not found", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Code, ShouldEqual, 404)
+				})
+			})
+
+			Convey("When I invalidate it with an update event", func() {
+
+				c.Invalidate(&Event{EntityType: "fake", Type: EventTypeUpdate, DataMap: []map[string]interface{}{{"ID": "x"}}})
+				c.FetchEntity(NewFakeObject("x"))
+
+				Convey("Then the decorated storer should have been called again", func() {
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I invalidate another entity", func() {
+
+				c.Invalidate(&Event{EntityType: "fake", Type: EventTypeUpdate, DataMap: []map[string]interface{}{{"ID": "y"}}})
+				c.FetchEntity(NewFakeObject("x"))
+
+				Convey("Then the decorated storer should not have been called again", func() {
+					So(s.count(), ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
+
+func TestCachingStorer_FetchChildren(t *testing.T) {
+
+	Convey("Given I have a CachingStorer with children", t, func() {
+
+		r := NewFakeRootObject()
+		s := &countingStorer{MemoryStorer: NewMemoryStorer(r)}
+		c := NewCachingStorer(s, 0, 0)
+
+		c.CreateChild(r, NewFakeObject("1"))
+		c.CreateChild(r, NewFakeObject("2"))
+
+		fetch := func(page int) (FakeObjectsList, *FetchingInfo) {
+			var l FakeObjectsList
+			info := &FetchingInfo{Page: page, PageSize: 1}
+			c.FetchChildren(r, FakeIdentity, &l, info)
+			return l, info
+		}
+
+		Convey("When I fetch the same page twice", func() {
+
+			l1, _ := fetch(0)
+			l2, info := fetch(0)
+
+			Convey("Then both lists should contain the first child", func() {
+				So(len(l1), ShouldEqual, 1)
+				So(l2, ShouldResemble, l1)
+			})
+
+			Convey("Then the fetching info should be restored", func() {
+				So(info.TotalCount, ShouldEqual, 2)
+			})
+
+			Convey("Then the decorated storer should have been called once", func() {
+				So(s.count(), ShouldEqual, 1)
+			})
+
+			Convey("When I fetch another page", func() {
+
+				l, _ := fetch(1)
+
+				Convey("Then I should get the second child", func() {
+					So(l[0].ID, ShouldEqual, "2")
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When a child is created and I fetch the page again", func() {
+
+				c.Invalidate(&Event{EntityType: "fake", Type: EventTypeCreate, DataMap: []map[string]interface{}{{"ID": "3"}}})
+				fetch(0)
+
+				Convey("Then the decorated storer should have been called again", func() {
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I fetch the children fresh", func() {
+
+				var l FakeObjectsList
+				c.FetchChildrenFresh(r, FakeIdentity, &l, &FetchingInfo{Page: 0, PageSize: 1})
+
+				Convey("Then the decorated storer should have been called again", func() {
+					So(s.count(), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+}
+
+func TestCachingStorer_Eviction(t *testing.T) {
+
+	Convey("Given I have a CachingStorer with a TTL and a maximum of two entries", t, func() {
+
+		r := NewFakeRootObject()
+		s := &countingStorer{MemoryStorer: NewMemoryStorer(r)}
+		c := NewCachingStorer(s, 50*time.Millisecond, 2)
+
+		for _, ID := range []string{"1", "2", "3"} {
+			c.CreateChild(r, NewFakeObject(ID))
+		}
+
+		Convey("When I fetch three objects", func() {
+
+			c.FetchEntity(NewFakeObject("1"))
+			c.FetchEntity(NewFakeObject("2"))
+			c.FetchEntity(NewFakeObject("1"))
+			c.FetchEntity(NewFakeObject("3"))
+
+			Convey("Then only two entries should be kept", func() {
+				So(c.Len(), ShouldEqual, 2)
+			})
+
+			Convey("Then the least recently used one should have been evicted", func() {
+				c.FetchEntity(NewFakeObject("1"))
+				So(s.count(), ShouldEqual, 3)
+				c.FetchEntity(NewFakeObject("2"))
+				So(s.count(), ShouldEqual, 4)
+			})
+
+			Convey("When the TTL expires", func() {
+
+				time.Sleep(60 * time.Millisecond)
+				c.FetchEntity(NewFakeObject("1"))
+
+				Convey("Then the decorated storer should have been called again", func() {
+					So(s.count(), ShouldEqual, 4)
+				})
+			})
+		})
+	})
+}
+
+func TestCachingStorer_InvalidateOn(t *testing.T) {
+
+	Convey("Given I have a CachingStorer invalidated by a PushCenter", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		c := NewCachingStorer(m, 0, 0)
+
+		o := NewFakeObject("x")
+		m.CreateChild(r, o)
+
+		p := NewPushCenter(m)
+		c.InvalidateOn(p)
+		startPushCenter(p)
+		defer p.Stop()
+
+		Convey("When the object is updated without the CachingStorer", func() {
+
+			c.FetchEntity(NewFakeObject("x"))
+
+			o.Name = "updated"
+			m.SaveEntity(o)
+
+			fetched := NewFakeObject("x")
+			for i := 0; i < 100 && fetched.Name != "updated"; i++ {
+				time.Sleep(10 * time.Millisecond)
+				c.FetchEntity(fetched)
+			}
+
+			Convey("Then the cache should be invalidated", func() {
+				So(fetched.Name, ShouldEqual, "updated")
+			})
+		})
+	})
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Diff returns the sorted names of the JSON attributes that differ between the given Identifiables.
+func Diff(old Identifiable, new Identifiable) ([]string, *Error) {
+
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	newData, err := json.Marshal(new)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	return diffJSON(oldData, newData)
+}
+
+// Changes returns the sorted names of the JSON attributes of the given Identifiable modified
+// since its snapshot. TrackChanges must be enabled, and the same instance of the Identifiable
+// must have been fetched, created or saved since then.
+func (s *Session) Changes(object Identifiable) ([]string, *Error) {
+
+	snapshot, berr := s.snapshot(object)
+	if berr != nil {
+		return nil, berr
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	return diffJSON(snapshot, data)
+}
+
+// SaveChanges saves the JSON attributes of the given Identifiable modified since its snapshot,
+// and returns their names. Attributes removed from the JSON representation are sent as null.
+// If nothing has been modified, nothing is sent to the server and no name is returned.
+// TrackChanges must be enabled.
+func (s *Session) SaveChanges(object Identifiable) ([]string, *Error) {
+
+	snapshot, berr := s.snapshot(object)
+	if berr != nil {
+		return nil, berr
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	changes, berr := diffJSON(snapshot, data)
+	if berr != nil || len(changes) == 0 {
+		return nil, berr
+	}
+
+	attributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	payload := map[string]interface{}{}
+	for _, name := range changes {
+		payload[name] = attributes[name]
+	}
+
+	url, berr := s.getPersonalURL(object)
+	if berr != nil {
+		return nil, berr
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := json.NewEncoder(buffer).Encode(payload); err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	if berr := s.put(object, url, buffer); berr != nil {
+		return nil, berr
+	}
+
+	return changes, nil
+}
+
+// defaultSnapshotsSize is the number of snapshots kept when no SnapshotsSize is given.
+const defaultSnapshotsSize = 1000
+
+// snapshotEntry represents the snapshot of an Identifiable.
+type snapshotEntry struct {
+	object Identifiable
+	data   []byte
+}
+
+// takeSnapshot saves the JSON representation of the given Identifiable if TrackChanges is enabled.
+// The snapshot belongs to the given instance: other instances with the same identifier have their own.
+func (s *Session) takeSnapshot(object Identifiable) {
+
+	if _, isRoot := object.(Rootable); !s.TrackChanges || isRoot || object.Identifier() == "" {
+		return
+	}
+
+	// Only the pointers identify an instance
+	if reflect.ValueOf(object).Kind() != reflect.Ptr {
+		return
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return
+	}
+
+	s.snapshotsLock.Lock()
+	defer s.snapshotsLock.Unlock()
+
+	if element, ok := s.snapshots[object]; ok {
+		element.Value.(*snapshotEntry).data = data
+		s.snapshotsLRU.MoveToFront(element)
+		return
+	}
+
+	if s.snapshots == nil {
+		s.snapshots = map[Identifiable]*list.Element{}
+	}
+
+	s.snapshots[object] = s.snapshotsLRU.PushFront(&snapshotEntry{object: object, data: data})
+
+	size := s.SnapshotsSize
+	if size <= 0 {
+		size = defaultSnapshotsSize
+	}
+
+	for s.snapshotsLRU.Len() > size {
+		oldest := s.snapshotsLRU.Back()
+		s.snapshotsLRU.Remove(oldest)
+		delete(s.snapshots, oldest.Value.(*snapshotEntry).object)
+	}
+}
+
+// takeSnapshots saves the JSON representation of each Identifiable of the given list if TrackChanges is enabled.
+func (s *Session) takeSnapshots(dest interface{}) {
+
+	if !s.TrackChanges {
+		return
+	}
+
+	items := reflect.Indirect(reflect.ValueOf(dest))
+	if items.Kind() != reflect.Slice {
+		return
+	}
+
+	for i := 0; i < items.Len(); i++ {
+		if object, ok := items.Index(i).Interface().(Identifiable); ok && object != nil {
+			s.takeSnapshot(object)
+		}
+	}
+}
+
+// snapshot returns the snapshot of the given Identifiable.
+func (s *Session) snapshot(object Identifiable) ([]byte, *Error) {
+
+	if !s.TrackChanges {
+		return nil, NewBambouError("Change tracking error", "The change tracking is not enabled")
+	}
+
+	s.snapshotsLock.Lock()
+	defer s.snapshotsLock.Unlock()
+
+	var element *list.Element
+	if reflect.ValueOf(object).Kind() == reflect.Ptr {
+		element = s.snapshots[object]
+	}
+
+	if element == nil {
+		return nil, NewBambouError("Change tracking error", fmt.Sprintf("There is no snapshot of %s", describeIdentifiable(object)))
+	}
+
+	s.snapshotsLRU.MoveToFront(element)
+
+	return element.Value.(*snapshotEntry).data, nil
+}
+
+// forgetSnapshot deletes the snapshot of the given Identifiable.
+func (s *Session) forgetSnapshot(object Identifiable) {
+
+	if reflect.ValueOf(object).Kind() != reflect.Ptr {
+		return
+	}
+
+	s.snapshotsLock.Lock()
+	defer s.snapshotsLock.Unlock()
+
+	if element, ok := s.snapshots[object]; ok {
+		s.snapshotsLRU.Remove(element)
+		delete(s.snapshots, object)
+	}
+}
+
+// clearSnapshots deletes all the snapshots.
+func (s *Session) clearSnapshots() {
+
+	s.snapshotsLock.Lock()
+	defer s.snapshotsLock.Unlock()
+
+	s.snapshots = nil
+	s.snapshotsLRU.Init()
+}
+
+// diffJSON returns the sorted names of the attributes that differ between the given JSON objects.
+func diffJSON(oldData []byte, newData []byte) ([]string, *Error) {
+
+	oldAttributes := map[string]interface{}{}
+	if err := json.Unmarshal(oldData, &oldAttributes); err != nil {
+		return nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	newAttributes := map[string]interface{}{}
+	if err := json.Unmarshal(newData, &newAttributes); err != nil {
+		return nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	var changes []string
+
+	for name, value := range newAttributes {
+		if old, ok := oldAttributes[name]; !ok || !reflect.DeepEqual(old, value) {
+			changes = append(changes, name)
+		}
+	}
+
+	for name := range oldAttributes {
+		if _, ok := newAttributes[name]; !ok {
+			changes = append(changes, name)
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes, nil
+}
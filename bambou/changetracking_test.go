@@ -0,0 +1,226 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChangeTracking_Diff(t *testing.T) {
+
+	Convey("Given I have two objects", t, func() {
+
+		o1 := &thing{ID: "x", Name: "a", Description: "old"}
+		o2 := &thing{ID: "x", Name: "b"}
+
+		Convey("When I diff them", func() {
+
+			changes, err := Diff(o1, o2)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the changed and removed attributes should be listed", func() {
+				So(changes, ShouldResemble, []string{"description", "name"})
+			})
+		})
+
+		Convey("When I diff an object with itself", func() {
+
+			changes, _ := Diff(o1, o1)
+
+			Convey("Then there should be no changes", func() {
+				So(changes, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestChangeTracking_SaveChanges(t *testing.T) {
+
+	Convey("Given I have a session tracking changes and a server", t, func() {
+
+		var requests []string
+		name := "a"
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests = append(requests, r.Method+" "+string(body))
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"ID": "x", "name": "%s", "description": "old"}]`, name)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.TrackChanges = true
+
+		Convey("When I fetch an object", func() {
+
+			o := &thing{ID: "x"}
+			session.FetchEntity(o)
+
+			Convey("Then there should be no changes", func() {
+				changes, err := session.Changes(o)
+				So(err, ShouldBeNil)
+				So(changes, ShouldBeEmpty)
+			})
+
+			Convey("When I save its changes without modifying it", func() {
+
+				changes, err := session.SaveChanges(o)
+
+				Convey("Then nothing should be sent", func() {
+					So(err, ShouldBeNil)
+					So(changes, ShouldBeEmpty)
+					So(len(requests), ShouldEqual, 1)
+				})
+			})
+
+			Convey("When I modify and remove attributes and save its changes", func() {
+
+				o.Name = "b"
+				o.Description = ""
+				changes, err := session.SaveChanges(o)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the changes should be returned", func() {
+					So(changes, ShouldResemble, []string{"description", "name"})
+				})
+
+				Convey("Then only the changes should be sent", func() {
+					So(requests[1], ShouldEqual, "PUT {\"description\":null,\"name\":\"b\"}\n")
+				})
+
+				Convey("Then the snapshot should be updated from the response", func() {
+					changes, _ := session.Changes(o)
+					So(changes, ShouldBeEmpty)
+				})
+			})
+
+			Convey("When I delete it and save its changes", func() {
+
+				session.DeleteEntity(o)
+				_, err := session.SaveChanges(o)
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When I fetch two instances of an object modified by someone else in between", func() {
+
+			o1 := &thing{ID: "x"}
+			session.FetchEntity(o1)
+			name = "b"
+			o2 := &thing{ID: "x"}
+			session.FetchEntity(o2)
+
+			changes, err := session.SaveChanges(o1)
+
+			Convey("Then the unmodified instance should have no changes to save", func() {
+				So(err, ShouldBeNil)
+				So(changes, ShouldBeEmpty)
+				So(len(requests), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I fetch children and modify one of them", func() {
+
+			var things []*thing
+			session.FetchChildren(session.Root(), thingIdentity, &things, nil)
+			things[0].Description = "new"
+
+			changes, err := session.Changes(things[0])
+
+			Convey("Then the modification should be listed", func() {
+				So(err, ShouldBeNil)
+				So(changes, ShouldResemble, []string{"description"})
+			})
+		})
+
+		Convey("When I limit the snapshots to one and fetch two instances", func() {
+
+			session.SnapshotsSize = 1
+			o1 := &thing{ID: "x"}
+			session.FetchEntity(o1)
+			o2 := &thing{ID: "x"}
+			session.FetchEntity(o2)
+
+			_, err1 := session.Changes(o1)
+			_, err2 := session.Changes(o2)
+
+			Convey("Then the snapshot of the first instance should have been forgotten", func() {
+				So(err1, ShouldNotBeNil)
+				So(err2, ShouldBeNil)
+				So(len(session.snapshots), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I create an object and modify it", func() {
+
+			o := &thing{Name: "a"}
+			session.CreateChild(session.Root(), o)
+			o.Description = "new"
+
+			changes, err := session.Changes(o)
+
+			Convey("Then the modification should be listed", func() {
+				So(err, ShouldBeNil)
+				So(changes, ShouldResemble, []string{"description"})
+			})
+		})
+
+		Convey("When I save the changes of an object that has not been fetched", func() {
+
+			_, err := session.SaveChanges(&thing{ID: "y"})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Change tracking error")
+			})
+		})
+
+		Convey("When I disable the change tracking and list the changes", func() {
+
+			session.TrackChanges = false
+			o := &thing{ID: "x"}
+			session.FetchEntity(o)
+			_, err := session.Changes(o)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
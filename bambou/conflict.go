@@ -0,0 +1,154 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// Conflict contains the local and server versions of an Identifiable modified by someone else.
+type Conflict struct {
+	Local  Identifiable
+	Server Identifiable
+}
+
+// NewConflictError returns a new *Error describing a Conflict between the given
+// local and server versions of an Identifiable.
+func NewConflictError(local Identifiable, server Identifiable) *Error {
+
+	berr := NewBambouError("Conflict", fmt.Sprintf("%s has been modified on the server", describeIdentifiable(local)))
+	berr.Code = http.StatusConflict
+	berr.Conflict = &Conflict{
+		Local:  local,
+		Server: server,
+	}
+
+	return berr
+}
+
+// IsConflict returns true if the Error describes a Conflict.
+func (be *Error) IsConflict() bool {
+
+	return be.Conflict != nil
+}
+
+// checkVersion verifies that the given Identifiable has not been modified on the server
+// if OptimisticConcurrency is enabled. It returns the ETag to send with the update, if any.
+// Without ETag, the server cannot reject the update, so a change saved between the
+// verification and the update is not detected.
+func (s *Session) checkVersion(object Identifiable, url string) (string, *Error) {
+
+	if !s.OptimisticConcurrency {
+		return "", nil
+	}
+
+	etag := ""
+	if v, ok := s.validator(url); ok {
+		etag = v.etag
+	}
+
+	local, hasDate := lastUpdatedDate(object)
+	if etag == "" && !hasDate {
+		return "", nil
+	}
+
+	server, header, berr := s.fetchServerVersion(object, url)
+	if berr != nil {
+		return "", berr
+	}
+
+	if etag != "" {
+		if header.Get("ETag") != etag {
+			return "", NewConflictError(object, server)
+		}
+		return etag, nil
+	}
+
+	if remote, _ := lastUpdatedDate(server); remote != local {
+		return "", NewConflictError(object, server)
+	}
+
+	return "", nil
+}
+
+// newConflictError returns a new *Error describing a Conflict with the current server
+// version of the given Identifiable, or the error of its fetching.
+func (s *Session) newConflictError(object Identifiable, url string) *Error {
+
+	server, _, berr := s.fetchServerVersion(object, url)
+	if berr != nil {
+		return berr
+	}
+
+	return NewConflictError(object, server)
+}
+
+// fetchServerVersion fetches the given Identifiable from the given URL into a new instance of its type,
+// without sending conditional headers nor sharing a response fetched concurrently, which could
+// be older. It returns the new instance and the headers of the response.
+func (s *Session) fetchServerVersion(object Identifiable, url string) (Identifiable, http.Header, *Error) {
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, NewBambouError("HTTP transaction error", err.Error())
+	}
+
+	response, berr := s.fetchUncoalesced(request, nil)
+	if berr != nil {
+		return nil, nil, berr
+	}
+
+	server := reflect.New(reflect.TypeOf(object).Elem()).Interface().(Identifiable)
+
+	arr := IdentifiablesList{server}
+	if err := json.Unmarshal(response.body, &arr); err != nil {
+		return nil, nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return server, response.header, nil
+}
+
+// lastUpdatedDate returns the lastUpdatedDate of the given Identifiable, if it has one.
+func lastUpdatedDate(object Identifiable) (string, bool) {
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", false
+	}
+
+	attributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return "", false
+	}
+
+	date, ok := attributes["lastUpdatedDate"]
+	if !ok || date == nil || date == "" {
+		return "", false
+	}
+
+	return fmt.Sprint(date), true
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConflict_NewConflictError(t *testing.T) {
+
+	Convey("Given I create a new conflict error", t, func() {
+
+		local := &FakeObject{ID: "xxx", Name: "local"}
+		server := &FakeObject{ID: "xxx", Name: "server"}
+		e := NewConflictError(local, server)
+
+		Convey("Then Code should be 409", func() {
+			So(e.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Then it should be a conflict", func() {
+			So(e.IsConflict(), ShouldBeTrue)
+			So(e.Conflict.Local, ShouldEqual, local)
+			So(e.Conflict.Server, ShouldEqual, server)
+		})
+
+		Convey("Then a regular error should not be a conflict", func() {
+			So(NewError(409, "nope").IsConflict(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSession_OptimisticConcurrency(t *testing.T) {
+
+	Convey("Given I have a server storing a dated entity and a session with optimistic concurrency", t, func() {
+
+		var lock sync.Mutex
+		var puts []string
+		serverDate := "1000"
+		serverETag := ""
+		preconditionFailed := false
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if serverETag != "" {
+				w.Header().Set("ETag", serverETag)
+			}
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.Method == "PUT" {
+				puts = append(puts, r.Header.Get("If-Match"))
+				if preconditionFailed {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+			}
+
+			fmt.Fprintf(w, `[{"ID": "xxx", "name": "server", "lastUpdatedDate": "%s"}]`, serverDate)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.OptimisticConcurrency = true
+
+		Convey("When I save an entity that is up to date", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx", Name: "local"}, LastUpdatedDate: "1000"}
+			err := session.SaveEntity(o)
+
+			Convey("Then the error should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the entity should have been saved", func() {
+				So(len(puts), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I save an entity that has been modified on the server", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx", Name: "local"}, LastUpdatedDate: "900"}
+			err := session.SaveEntity(o)
+
+			Convey("Then the error should be a conflict", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusConflict)
+				So(err.IsConflict(), ShouldBeTrue)
+			})
+
+			Convey("Then the conflict should contain both versions", func() {
+				So(err.Conflict.Local, ShouldEqual, o)
+				So(err.Conflict.Server.(*datedFakeObject).Name, ShouldEqual, "server")
+				So(err.Conflict.Server.(*datedFakeObject).LastUpdatedDate, ShouldEqual, "1000")
+			})
+
+			Convey("Then the entity should not have been saved", func() {
+				So(len(puts), ShouldEqual, 0)
+				So(o.Name, ShouldEqual, "local")
+			})
+		})
+
+		Convey("When I save an entity without lastUpdatedDate", func() {
+
+			err := session.SaveEntity(&FakeObject{ID: "xxx", Name: "local"})
+
+			Convey("Then the entity should have been saved without verification", func() {
+				So(err, ShouldBeNil)
+				So(puts, ShouldResemble, []string{""})
+			})
+		})
+
+		Convey("When I fetch an entity returning an ETag", func() {
+
+			serverETag = `"v1"`
+			o := NewFakeObject("xxx")
+			session.FetchEntity(o)
+
+			Convey("When I save it", func() {
+
+				err := session.SaveEntity(o)
+
+				Convey("Then the update should be conditional", func() {
+					So(err, ShouldBeNil)
+					So(puts, ShouldResemble, []string{`"v1"`})
+				})
+			})
+
+			Convey("When the server ETag changes and I save it", func() {
+
+				lock.Lock()
+				serverETag = `"v2"`
+				lock.Unlock()
+
+				err := session.SaveEntity(o)
+
+				Convey("Then the error should be a conflict", func() {
+					So(err, ShouldNotBeNil)
+					So(err.IsConflict(), ShouldBeTrue)
+					So(len(puts), ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the server rejects the precondition", func() {
+
+				lock.Lock()
+				preconditionFailed = true
+				lock.Unlock()
+
+				err := session.SaveEntity(o)
+
+				Convey("Then the error should be a conflict", func() {
+					So(err, ShouldNotBeNil)
+					So(err.IsConflict(), ShouldBeTrue)
+					So(err.Conflict.Server.(*FakeObject).Name, ShouldEqual, "server")
+				})
+			})
+		})
+	})
+}
+
+func TestSession_OptimisticConcurrencyWithCoalescedRequests(t *testing.T) {
+
+	Convey("Given I have a server answering slowly to a first fetch and a session coalescing requests", t, func() {
+
+		var lock sync.Mutex
+		serverDate := "1000"
+		fetches := 0
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			date := serverDate
+			fetches++
+			first := fetches == 1
+			lock.Unlock()
+
+			if first {
+				close(started)
+				select {
+				case <-release:
+				case <-time.After(time.Second):
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"ID": "xxx", "name": "server", "lastUpdatedDate": "%s"}]`, date)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.OptimisticConcurrency = true
+		session.CoalesceRequests = true
+
+		Convey("When the entity is modified during the first fetch and I save it with the new version", func() {
+
+			done := make(chan struct{})
+			go func() {
+				session.FetchEntity(&datedFakeObject{FakeObject: FakeObject{ID: "xxx"}})
+				close(done)
+			}()
+			<-started
+
+			lock.Lock()
+			serverDate = "1100"
+			lock.Unlock()
+
+			err := session.SaveEntity(&datedFakeObject{FakeObject: FakeObject{ID: "xxx", Name: "local"}, LastUpdatedDate: "1100"})
+
+			close(release)
+			<-done
+
+			Convey("Then the version should not be verified against the older response", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CursorStore is the interface that must be implemented by objects that persist
+// the identifier of the last notification handled by a PushCenter, so it can
+// resume from there after a restart.
+type CursorStore interface {
+
+	// LoadCursor returns the saved cursor, or an empty string if there is none.
+	LoadCursor() (string, error)
+
+	// SaveCursor saves the given cursor.
+	SaveCursor(string) error
+}
+
+// MemoryCursorStore is a CursorStore keeping the cursor in memory.
+type MemoryCursorStore struct {
+	cursor string
+	lock   sync.RWMutex
+}
+
+// NewMemoryCursorStore returns a new *MemoryCursorStore.
+func NewMemoryCursorStore() *MemoryCursorStore {
+
+	return &MemoryCursorStore{}
+}
+
+// LoadCursor returns the saved cursor.
+func (s *MemoryCursorStore) LoadCursor() (string, error) {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.cursor, nil
+}
+
+// SaveCursor saves the given cursor.
+func (s *MemoryCursorStore) SaveCursor(cursor string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.cursor = cursor
+
+	return nil
+}
+
+// FileCursorStore is a CursorStore keeping the cursor in a file.
+// The file is replaced atomically, so it always contains a complete cursor.
+type FileCursorStore struct {
+	path string
+	lock sync.Mutex
+}
+
+// NewFileCursorStore returns a new *FileCursorStore using the file at the given path.
+func NewFileCursorStore(path string) *FileCursorStore {
+
+	return &FileCursorStore{
+		path: path,
+	}
+}
+
+// LoadCursor returns the cursor saved in the file, or an empty string if the file does not exist.
+func (s *FileCursorStore) LoadCursor() (string, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SaveCursor writes the given cursor in a temporary file and renames it to the path of the FileCursorStore.
+func (s *FileCursorStore) SaveCursor(cursor string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.WriteString(cursor); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCursorStore_MemoryCursorStore(t *testing.T) {
+
+	Convey("Given I create a new MemoryCursorStore", t, func() {
+
+		s := NewMemoryCursorStore()
+
+		Convey("When I load the cursor", func() {
+
+			cursor, err := s.LoadCursor()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the cursor should be empty", func() {
+				So(cursor, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I save a cursor and load it", func() {
+
+			err := s.SaveCursor("x")
+			cursor, _ := s.LoadCursor()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the cursor should be x", func() {
+				So(cursor, ShouldEqual, "x")
+			})
+		})
+	})
+}
+
+func TestCursorStore_FileCursorStore(t *testing.T) {
+
+	Convey("Given I create a new FileCursorStore in a directory", t, func() {
+
+		dir, _ := ioutil.TempDir("", "bambou")
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "cursor")
+		s := NewFileCursorStore(path)
+
+		Convey("When I load the cursor before the file exists", func() {
+
+			cursor, err := s.LoadCursor()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the cursor should be empty", func() {
+				So(cursor, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I save two cursors", func() {
+
+			err1 := s.SaveCursor("x")
+			err2 := s.SaveCursor("y")
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then a new FileCursorStore should load the last one", func() {
+				cursor, err := NewFileCursorStore(path).LoadCursor()
+				So(err, ShouldBeNil)
+				So(cursor, ShouldEqual, "y")
+			})
+
+			Convey("Then no temporary file should be left", func() {
+				files, _ := ioutil.ReadDir(dir)
+				So(len(files), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I save a cursor in a directory that doesn't exist", func() {
+
+			err := NewFileCursorStore(filepath.Join(dir, "nope", "cursor")).SaveCursor("x")
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I load a cursor from a directory", func() {
+
+			_, err := NewFileCursorStore(dir).LoadCursor()
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// OverflowPolicy defines what a PushCenter does with an event when the queue of a worker is full.
+//
+// The policies dropping events report a "Dispatch error" to the Errors channel of the
+// PushCenter. Once an event has been dropped, the cursor is not saved anymore until the
+// PushCenter is restarted, so the dropped events are sent again from the last saved
+// cursor, along with the following ones, when it starts again.
+type OverflowPolicy int
+
+// Supported values of OverflowPolicy.
+const (
+	// OverflowBlock waits until the worker can accept the event.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest event waiting in the queue of the worker.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the new event.
+	OverflowDropNewest
+)
+
+// pendingNotification tracks the number of events of a Notification that are not handled yet.
+type pendingNotification struct {
+	uuid      string
+	remaining int
+	dropped   bool
+}
+
+// dispatchItem represents an Event waiting to be handled by a worker.
+type dispatchItem struct {
+	event        *Event
+	notification *pendingNotification
+}
+
+// dispatcher calls the handlers of a PushCenter from a pool of workers.
+// Events are assigned to a worker according to their entity, so the events
+// of the same entity are handled in sequence. The cursor is only saved
+// once all the events of a Notification and of the previous ones are handled,
+// and never after a Notification with a dropped event.
+type dispatcher struct {
+	pushCenter *PushCenter
+	queues     []chan *dispatchItem
+	stop       chan bool
+	pending    []*pendingNotification
+	frozen     bool
+	sequence   int
+	saved      int
+	lock       sync.Mutex
+	saveLock   sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// newDispatcher returns a new *dispatcher and starts its workers until the given channel is closed.
+func newDispatcher(pushCenter *PushCenter, stop chan bool) *dispatcher {
+
+	queueSize := pushCenter.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultPushCenterQueueSize
+	}
+
+	d := &dispatcher{
+		pushCenter: pushCenter,
+		queues:     make([]chan *dispatchItem, pushCenter.Workers),
+		stop:       stop,
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan *dispatchItem, queueSize)
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+
+	return d
+}
+
+// dispatch sends the events of the given Notification to the workers.
+func (d *dispatcher) dispatch(notification *Notification) {
+
+	pending := &pendingNotification{
+		uuid:      notification.UUID,
+		remaining: len(notification.Events),
+	}
+
+	d.lock.Lock()
+	d.pending = append(d.pending, pending)
+	d.lock.Unlock()
+
+	if pending.remaining == 0 {
+		d.commit()
+		return
+	}
+
+	for _, event := range notification.Events {
+
+		item := &dispatchItem{
+			event:        event,
+			notification: pending,
+		}
+
+		if !prepareEvent(event) {
+			d.done(item)
+			continue
+		}
+
+		if !d.enqueue(item) {
+			return
+		}
+	}
+}
+
+// enqueue sends the given item to the queue of its worker according to the OverflowPolicy.
+// It returns false if the dispatcher has been stopped.
+func (d *dispatcher) enqueue(item *dispatchItem) bool {
+
+	queue := d.queues[d.workerIndex(item.event)]
+
+	for {
+
+		select {
+		case queue <- item:
+			return true
+		default:
+		}
+
+		switch d.pushCenter.OverflowPolicy {
+
+		case OverflowDropOldest:
+			select {
+			case dropped := <-queue:
+				d.pushCenter.report(NewBambouError("Dispatch error", "The queue of the worker is full, the oldest event has been dropped"))
+				d.drop(dropped)
+			default:
+			}
+
+		case OverflowDropNewest:
+			d.pushCenter.report(NewBambouError("Dispatch error", "The queue of the worker is full, the event has been dropped"))
+			d.drop(item)
+			return true
+
+		default:
+			select {
+			case queue <- item:
+				return true
+			case <-d.stop:
+				return false
+			}
+		}
+	}
+}
+
+// workerIndex returns the index of the worker handling the entity of the given Event.
+func (d *dispatcher) workerIndex(event *Event) int {
+
+	h := fnv.New32a()
+	h.Write([]byte(event.EntityType + "/" + event.EntityID()))
+
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// work handles the items of the given queue until the dispatcher is stopped.
+func (d *dispatcher) work(queue chan *dispatchItem) {
+
+	defer d.wg.Done()
+
+	for {
+		select {
+		case item := <-queue:
+			d.pushCenter.handle(item.event)
+			d.done(item)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// done marks the given item as handled.
+func (d *dispatcher) done(item *dispatchItem) {
+
+	d.lock.Lock()
+	item.notification.remaining--
+	d.lock.Unlock()
+
+	d.commit()
+}
+
+// drop marks the given item as dropped without being handled.
+func (d *dispatcher) drop(item *dispatchItem) {
+
+	d.lock.Lock()
+	item.notification.dropped = true
+	d.lock.Unlock()
+
+	d.done(item)
+}
+
+// commit saves the cursor of the last Notification handled
+// after all the previous ones, unless an event has been dropped.
+func (d *dispatcher) commit() {
+
+	d.lock.Lock()
+
+	cursor := ""
+	for len(d.pending) > 0 && d.pending[0].remaining == 0 {
+		if d.pending[0].dropped {
+			d.frozen = true
+		}
+		if !d.frozen {
+			cursor = d.pending[0].uuid
+		}
+		d.pending = d.pending[1:]
+	}
+
+	if cursor == "" {
+		d.lock.Unlock()
+		return
+	}
+
+	d.sequence++
+	sequence := d.sequence
+
+	d.lock.Unlock()
+
+	d.saveLock.Lock()
+	defer d.saveLock.Unlock()
+
+	if sequence > d.saved {
+		d.saved = sequence
+		d.pushCenter.saveCursor(cursor)
+	}
+}
+
+// reset forgets the Notifications that are not handled yet,
+// and saves the cursors again.
+func (d *dispatcher) reset() {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.pending = nil
+	d.frozen = false
+}
+
+// wait waits for the workers to return.
+func (d *dispatcher) wait() {
+
+	d.wg.Wait()
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// idsOnDifferentWorkers returns two FakeObject identifiers handled by different workers.
+func idsOnDifferentWorkers(workers int) (string, string) {
+
+	d := &dispatcher{queues: make([]chan *dispatchItem, workers)}
+	index := func(ID string) int {
+		return d.workerIndex(&Event{EntityType: FakeIdentity.Name, DataMap: []map[string]interface{}{{"ID": ID}}})
+	}
+
+	for i := 1; ; i++ {
+		if ID := fmt.Sprintf("%d", i); index(ID) != index("0") {
+			return "0", ID
+		}
+	}
+}
+
+// receiveEvents receives n events from the given channel or returns what has been received after a second.
+func receiveEvents(c chan *Event, n int) []*Event {
+
+	var events []*Event
+	timeout := time.After(time.Second)
+
+	for len(events) < n {
+		select {
+		case e := <-c:
+			events = append(events, e)
+		case <-timeout:
+			return events
+		}
+	}
+
+	return events
+}
+
+func TestDispatcher_Ordering(t *testing.T) {
+
+	Convey("Given I have a PushCenter with workers on a MemoryStorer", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+		p.Workers = 4
+
+		lock := &sync.Mutex{}
+		names := map[string][]string{}
+		received := make(chan *Event, 100)
+
+		p.RegisterHandlerForIdentity(func(e *Event) {
+			time.Sleep(time.Duration(len(e.EntityID())) * time.Millisecond)
+			lock.Lock()
+			names[e.EntityID()] = append(names[e.EntityID()], e.DataMap[0]["name"].(string))
+			lock.Unlock()
+			received <- e
+		}, FakeIdentity)
+
+		Convey("When I update several objects several times", func() {
+
+			startPushCenter(p)
+
+			var objects []*FakeObject
+			for i := 0; i < 5; i++ {
+				o := NewFakeObject(fmt.Sprintf("%d", i*11111))
+				o.Name = "0"
+				m.CreateChild(r, o)
+				objects = append(objects, o)
+			}
+
+			for n := 1; n < 5; n++ {
+				for _, o := range objects {
+					o.Name = fmt.Sprintf("%d", n)
+					m.SaveEntity(o)
+				}
+			}
+
+			events := receiveEvents(received, 25)
+			p.Stop()
+
+			Convey("Then all the events should have been handled", func() {
+				So(len(events), ShouldEqual, 25)
+			})
+
+			Convey("Then the events of each object should have been handled in order", func() {
+				for _, o := range objects {
+					So(names[o.ID], ShouldResemble, []string{"0", "1", "2", "3", "4"})
+				}
+			})
+		})
+	})
+}
+
+func TestDispatcher_Parallelism(t *testing.T) {
+
+	Convey("Given I have a PushCenter with workers and a cursor store", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		cursors := NewMemoryCursorStore()
+
+		p := NewPushCenter(m)
+		p.Workers = 2
+		p.CursorStore = cursors
+
+		slowID, fastID := idsOnDifferentWorkers(p.Workers)
+		release := make(chan struct{})
+		received := make(chan *Event, 10)
+
+		p.RegisterHandlerForIdentity(func(e *Event) {
+			if e.EntityID() == slowID {
+				<-release
+			}
+			received <- e
+		}, FakeIdentity)
+
+		Convey("When a handler blocks on an event of an object", func() {
+
+			startPushCenter(p)
+			initial, _ := cursors.LoadCursor()
+
+			m.CreateChild(r, NewFakeObject(slowID))
+			m.CreateChild(r, NewFakeObject(fastID))
+
+			fast := receiveEvents(received, 1)
+			cursor, _ := cursors.LoadCursor()
+
+			close(release)
+			slow := receiveEvents(received, 1)
+			time.Sleep(50 * time.Millisecond)
+			p.Stop()
+
+			finalCursor, _ := cursors.LoadCursor()
+
+			Convey("Then the event of the other object should be handled", func() {
+				So(len(fast), ShouldEqual, 1)
+				So(fast[0].EntityID(), ShouldEqual, fastID)
+			})
+
+			Convey("Then the cursor should not be saved before the blocked event is handled", func() {
+				So(cursor, ShouldEqual, initial)
+			})
+
+			Convey("Then the blocked event should be handled once released", func() {
+				So(len(slow), ShouldEqual, 1)
+				So(slow[0].EntityID(), ShouldEqual, slowID)
+			})
+
+			Convey("Then the cursor should be saved once all the events are handled", func() {
+				So(finalCursor, ShouldNotEqual, initial)
+			})
+		})
+	})
+}
+
+func TestDispatcher_Overflow(t *testing.T) {
+
+	Convey("Given I have a PushCenter with one worker and a queue of one event", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+		p.Workers = 1
+		p.QueueSize = 1
+
+		release := make(chan struct{})
+		received := make(chan *Event, 10)
+
+		p.RegisterHandlerForIdentity(func(e *Event) {
+			<-release
+			received <- e
+		}, FakeIdentity)
+
+		run := func() []*Event {
+
+			startPushCenter(p)
+			for i := 0; i < 3; i++ {
+				m.CreateChild(r, NewFakeObject(fmt.Sprintf("%d", i)))
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			close(release)
+			events := receiveEvents(received, 3)
+			p.Stop()
+
+			return events
+		}
+
+		Convey("When I use the block policy and create three objects", func() {
+
+			p.OverflowPolicy = OverflowBlock
+			events := run()
+
+			Convey("Then all the events should be handled", func() {
+				So(len(events), ShouldEqual, 3)
+			})
+
+			Convey("Then no error should be reported", func() {
+				So(len(p.Errors()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I use the drop oldest policy and create three objects", func() {
+
+			p.OverflowPolicy = OverflowDropOldest
+			events := run()
+
+			Convey("Then the first and last events should be handled", func() {
+				So(len(events), ShouldEqual, 2)
+				So(events[0].EntityID(), ShouldEqual, "0")
+				So(events[1].EntityID(), ShouldEqual, "2")
+			})
+
+			Convey("Then an error should be reported", func() {
+				So((<-p.Errors()).Title, ShouldEqual, "Dispatch error")
+			})
+
+			Convey("When I restart the push center", func() {
+
+				p.OverflowPolicy = OverflowBlock
+				p.Start()
+				replayed := receiveEvents(received, 2)
+				p.Stop()
+
+				Convey("Then the dropped event should be sent again", func() {
+					So(len(replayed), ShouldEqual, 2)
+					So(replayed[0].EntityID(), ShouldEqual, "1")
+					So(replayed[1].EntityID(), ShouldEqual, "2")
+				})
+			})
+		})
+
+		Convey("When I use the drop newest policy and create three objects", func() {
+
+			p.OverflowPolicy = OverflowDropNewest
+			events := run()
+
+			Convey("Then the first two events should be handled", func() {
+				So(len(events), ShouldEqual, 2)
+				So(events[0].EntityID(), ShouldEqual, "0")
+				So(events[1].EntityID(), ShouldEqual, "1")
+			})
+
+			Convey("Then an error should be reported", func() {
+				So((<-p.Errors()).Title, ShouldEqual, "Dispatch error")
+			})
+		})
+	})
+}
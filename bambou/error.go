@@ -43,6 +43,13 @@ type VsdError struct {
 type Error struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
+
+	// Code is the HTTP status code returned by the server, if any.
+	Code int `json:"-"`
+
+	// Conflict contains the local and server versions of an Identifiable
+	// that has been modified by someone else, if any.
+	Conflict *Conflict `json:"-"`
 }
 
 func NewBambouError(title, description string) *Error {
@@ -56,6 +63,7 @@ func NewError(code int, description string) *Error {
 	return &Error{
 		Title:       fmt.Sprintf("Error code: %d", code),
 		Description: description,
+		Code:        code,
 	}
 }
 
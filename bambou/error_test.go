@@ -45,6 +45,21 @@ func TestError_NewError(t *testing.T) {
 	})
 }
 
+func TestError_NewErrorWithCode(t *testing.T) {
+
+	Convey("Given I create a new Error with a code", t, func() {
+		e := NewError(404, "Description")
+
+		Convey("Then Title should be 'Error code: 404'", func() {
+			So(e.Title, ShouldEqual, "Error code: 404")
+		})
+
+		Convey("Then Code should be 404", func() {
+			So(e.Code, ShouldEqual, 404)
+		})
+	})
+}
+
 func TestError_Error(t *testing.T) {
 
 	Convey("Given I create a new Error", t, func() {
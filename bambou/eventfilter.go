@@ -0,0 +1,133 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+// EventFilter describes the Events a Subscription is interested in.
+// Every non zero field must match for an Event to match the EventFilter.
+type EventFilter struct {
+
+	// Identity is the Identity of the entities. AllIdentity or an empty Identity matches all entities.
+	Identity Identity
+
+	// Types are the accepted EventTypes. An empty list accepts all types.
+	Types []EventType
+
+	// UpdateMechanisms are the accepted UpdateMechanisms. An empty list accepts all mechanisms.
+	UpdateMechanisms []UpdateMechanism
+
+	// EntityID is the identifier of the entity. An Event containing several
+	// entities matches if one of them has this identifier.
+	EntityID string
+
+	// ParentID is the identifier of the parent of the entity. If EntityID is
+	// also set, both must match the same entity.
+	ParentID string
+
+	// Predicate is called with the Events matching all the other fields.
+	Predicate func(*Event) bool
+}
+
+// Matches returns true if the given Event matches the EventFilter.
+func (f EventFilter) Matches(event *Event) bool {
+
+	if f.Identity.Name != "" && f.Identity.Name != AllIdentity.Name && f.Identity.Name != event.EntityType {
+		return false
+	}
+
+	if len(f.Types) > 0 && !containsEventType(f.Types, event.Type) {
+		return false
+	}
+
+	if len(f.UpdateMechanisms) > 0 && !containsUpdateMechanism(f.UpdateMechanisms, event.UpdateMechanism) {
+		return false
+	}
+
+	if !f.matchesEntity(event) {
+		return false
+	}
+
+	if f.Predicate != nil && !f.Predicate(event) {
+		return false
+	}
+
+	return true
+}
+
+// matchesEntity returns true if one of the entities of the given Event matches
+// the EntityID and the ParentID of the EventFilter.
+func (f EventFilter) matchesEntity(event *Event) bool {
+
+	if f.EntityID == "" && f.ParentID == "" {
+		return true
+	}
+
+	for i := range event.DataMap {
+
+		if f.EntityID != "" && f.EntityID != event.entityAttribute(i, "ID") {
+			continue
+		}
+
+		if f.ParentID != "" && f.ParentID != event.entityAttribute(i, "parentID") {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// identity returns the Identity used to register the EventFilter.
+func (f EventFilter) identity() Identity {
+
+	if f.Identity.Name == "" {
+		return AllIdentity
+	}
+
+	return f.Identity
+}
+
+// containsEventType returns true if the given list contains the given EventType.
+func containsEventType(types []EventType, eventType EventType) bool {
+
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsUpdateMechanism returns true if the given list contains the given UpdateMechanism.
+func containsUpdateMechanism(mechanisms []UpdateMechanism, mechanism UpdateMechanism) bool {
+
+	for _, m := range mechanisms {
+		if m == mechanism {
+			return true
+		}
+	}
+
+	return false
+}
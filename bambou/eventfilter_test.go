@@ -0,0 +1,105 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventFilter_Matches(t *testing.T) {
+
+	Convey("Given I have an event", t, func() {
+
+		e := &Event{
+			EntityType:      "fake",
+			Type:            EventTypeUpdate,
+			UpdateMechanism: UpdateMechanismDefault,
+			DataMap:         []map[string]interface{}{{"ID": "xxx", "parentID": "yyy"}},
+		}
+
+		Convey("Then an empty filter should match it", func() {
+			So(EventFilter{}.Matches(e), ShouldBeTrue)
+		})
+
+		Convey("Then a filter on the all identity should match it", func() {
+			So(EventFilter{Identity: AllIdentity}.Matches(e), ShouldBeTrue)
+		})
+
+		Convey("Then a filter matching all the fields should match it", func() {
+			f := EventFilter{
+				Identity:         FakeIdentity,
+				Types:            []EventType{EventTypeCreate, EventTypeUpdate},
+				UpdateMechanisms: []UpdateMechanism{UpdateMechanismDefault},
+				EntityID:         "xxx",
+				ParentID:         "yyy",
+				Predicate:        func(e *Event) bool { return e.DataMap[0]["ID"] == "xxx" },
+			}
+			So(f.Matches(e), ShouldBeTrue)
+		})
+
+		Convey("Then a filter on another identity should not match it", func() {
+			So(EventFilter{Identity: Identity{"other", "others"}}.Matches(e), ShouldBeFalse)
+		})
+
+		Convey("Then a filter on other types should not match it", func() {
+			So(EventFilter{Types: []EventType{EventTypeCreate, EventTypeDelete}}.Matches(e), ShouldBeFalse)
+		})
+
+		Convey("Then a filter on another update mechanism should not match it", func() {
+			So(EventFilter{UpdateMechanisms: []UpdateMechanism{UpdateMechanismRefetch}}.Matches(e), ShouldBeFalse)
+		})
+
+		Convey("Then a filter on another entity ID should not match it", func() {
+			So(EventFilter{EntityID: "zzz"}.Matches(e), ShouldBeFalse)
+		})
+
+		Convey("Then a filter on another parent ID should not match it", func() {
+			So(EventFilter{ParentID: "zzz"}.Matches(e), ShouldBeFalse)
+		})
+
+		Convey("Then a filter with a predicate returning false should not match it", func() {
+			So(EventFilter{Predicate: func(*Event) bool { return false }}.Matches(e), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given I have an event with two entities", t, func() {
+
+		e := &Event{
+			EntityType: "fake",
+			DataMap:    []map[string]interface{}{{"ID": "1", "parentID": "p1"}, {"ID": "2", "parentID": "p2"}},
+		}
+
+		Convey("Then filters on the second entity should match it", func() {
+			So(EventFilter{EntityID: "2"}.Matches(e), ShouldBeTrue)
+			So(EventFilter{ParentID: "p2"}.Matches(e), ShouldBeTrue)
+			So(EventFilter{EntityID: "2", ParentID: "p2"}.Matches(e), ShouldBeTrue)
+		})
+
+		Convey("Then a filter matching different entities should not match it", func() {
+			So(EventFilter{EntityID: "1", ParentID: "p2"}.Matches(e), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,650 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ExportedObject represents an exported Identifiable with its descendants.
+type ExportedObject struct {
+	Identity    string                 `json:"identity" yaml:"identity"`
+	ID          string                 `json:"ID,omitempty" yaml:"ID,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Children    []*ExportedObject      `json:"children,omitempty" yaml:"children,omitempty"`
+	Assignments []*ExportedAssignment  `json:"assignments,omitempty" yaml:"assignments,omitempty"`
+}
+
+// ExportedAssignment represents the children with the given Identity name assigned to an ExportedObject.
+// The exported children are referenced by their paths, made of the Identity names and natural keys
+// of the exported root and of their ancestors, like `enterprise "acme"/domain "prod"/subnet "default"`.
+// The other children are referenced by their natural keys, so they can be resolved after being imported elsewhere.
+type ExportedAssignment struct {
+	Identity string   `json:"identity" yaml:"identity"`
+	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Keys     []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+}
+
+// ExportFormat represents the format of an exported document.
+type ExportFormat string
+
+// Supported ExportFormats.
+const (
+	ExportFormatJSON ExportFormat = "json"
+	ExportFormatYAML ExportFormat = "yaml"
+)
+
+// defaultReadOnlyAttributes are the attributes that are not imported by default.
+var defaultReadOnlyAttributes = []string{
+	"ID",
+	"parentID",
+	"parentType",
+	"owner",
+	"creationDate",
+	"lastUpdatedDate",
+	"lastUpdatedBy",
+}
+
+// Exporter exports an Identifiable and its descendants. The Identities
+// of the descendants and of the assigned children must be registered
+// in the DefaultIdentityRegistry.
+type Exporter struct {
+
+	// Children contains the Identities of the children to export,
+	// indexed by the Name of the Identity of their parent. If nil, the owned
+	// children of the IdentitySchemas of the DefaultIdentityRegistry are exported.
+	Children map[string][]Identity
+
+	// Assignments contains the Identities of the assigned children to export,
+	// indexed by the Name of the Identity of their parent. They should not
+	// be in Children for the same parent. If nil, the member children of the
+	// IdentitySchemas of the DefaultIdentityRegistry are exported.
+	Assignments map[string][]Identity
+
+	// KeyFunc returns the natural key of an exported Identifiable. By default, the name is used.
+	KeyFunc func(Identifiable) string
+
+	storer Storer
+}
+
+// NewExporter returns a new *Exporter exporting the given children Identities with the given Storer.
+func NewExporter(storer Storer, children map[string][]Identity) *Exporter {
+
+	return &Exporter{
+		Children: children,
+		storer:   storer,
+	}
+}
+
+// Export exports the given Identifiable and its descendants. The assigned children
+// that are exported are referenced by their paths, the other ones by their natural keys.
+// It returns an error if an assigned child is exported with the same path as another object.
+func (e *Exporter) Export(ctx context.Context, root Identifiable) (*ExportedObject, *Error) {
+
+	assignments := e.Assignments
+	if assignments == nil {
+		assignments = DefaultIdentityRegistry.MemberIdentities()
+	}
+
+	exported := map[*WalkNode]*ExportedObject{}
+	paths := map[*WalkNode]string{}
+	located := map[string]string{}
+	counts := map[string]int{}
+	var assigned []*exportedAssignees
+	var result *ExportedObject
+
+	walker := NewWalker(e.storer, e.Children)
+
+	berr := walker.Walk(ctx, root, func(ctx context.Context, node *WalkNode) *Error {
+
+		object, assignees, berr := e.export(node.Object, assignments[node.Object.Identity().Name])
+		if berr != nil {
+			return berr
+		}
+
+		path := describeKey(object.Identity, naturalKey(node.Object, e.KeyFunc))
+
+		if node.Parent == nil {
+			result = object
+		} else {
+			parent := exported[node.Parent]
+			parent.Children = append(parent.Children, object)
+			path = paths[node.Parent] + "/" + path
+		}
+
+		exported[node] = object
+		paths[node] = path
+		counts[path]++
+		located[object.Identity+"/"+object.ID] = path
+		assigned = append(assigned, assignees...)
+
+		return nil
+	})
+
+	if berr != nil {
+		return nil, berr
+	}
+
+	for _, assignees := range assigned {
+		for _, child := range assignees.children {
+
+			if path, ok := located[child.Identity().Name+"/"+child.Identifier()]; ok {
+
+				if counts[path] > 1 {
+					return nil, NewBambouError("Export error", fmt.Sprintf("The assigned %s cannot be referenced: several exported objects have the path %s", describeIdentifiable(child), path))
+				}
+
+				assignees.assignment.Paths = append(assignees.assignment.Paths, path)
+				continue
+			}
+
+			key := naturalKey(child, e.KeyFunc)
+			if key == "" {
+				return nil, NewBambouError("Export error", fmt.Sprintf("The assigned %s has no natural key", describeIdentifiable(child)))
+			}
+
+			assignees.assignment.Keys = append(assignees.assignment.Keys, key)
+		}
+	}
+
+	return result, nil
+}
+
+// exportedAssignees represents the children of an ExportedAssignment.
+type exportedAssignees struct {
+	assignment *ExportedAssignment
+	children   IdentifiablesList
+}
+
+// export returns the ExportedObject of the given Identifiable without its owned children,
+// and its children assigned with the given Identities, that must be added to its ExportedAssignments.
+func (e *Exporter) export(object Identifiable, assignments []Identity) (*ExportedObject, []*exportedAssignees, *Error) {
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, nil, NewBambouError("JSON error", err.Error())
+	}
+
+	attributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	delete(attributes, "ID")
+	delete(attributes, "parentID")
+	delete(attributes, "parentType")
+
+	exported := &ExportedObject{
+		Identity:   object.Identity().Name,
+		ID:         object.Identifier(),
+		Attributes: attributes,
+	}
+
+	var assigned []*exportedAssignees
+
+	for _, identity := range assignments {
+
+		children, berr := FetchAllChildren(e.storer, object, identity)
+		if berr != nil {
+			return nil, nil, berr
+		}
+
+		if len(children) == 0 {
+			continue
+		}
+
+		assignment := &ExportedAssignment{Identity: identity.Name}
+		exported.Assignments = append(exported.Assignments, assignment)
+		assigned = append(assigned, &exportedAssignees{assignment: assignment, children: children})
+	}
+
+	return exported, assigned, nil
+}
+
+// Importer imports ExportedObjects. Their Identities and the Identities
+// of their assignments must be registered in the DefaultIdentityRegistry.
+type Importer struct {
+
+	// ReadOnlyAttributes are the attributes that are not imported. By default, the identifiers,
+	// ownership and dates are not imported, nor the read-only attributes of the IdentitySchemas
+	// of the DefaultIdentityRegistry.
+	ReadOnlyAttributes []string
+
+	// KeyFunc returns the natural key of an imported Identifiable. By default, the name is used.
+	KeyFunc func(Identifiable) string
+
+	// Resolve, if set, is called to find the assigned Identifiable with the given
+	// Identity and natural key when it is not part of the imported document.
+	Resolve func(identity Identity, key string) (Identifiable, *Error)
+
+	storer Storer
+}
+
+// NewImporter returns a new *Importer using the given Storer.
+func NewImporter(storer Storer) *Importer {
+
+	return &Importer{
+		storer: storer,
+	}
+}
+
+// Import creates the given ExportedObject and its descendants under the given parent,
+// then assigns their children. The Identifiables get new identifiers: the returned map
+// contains the new identifier of each exported identifier. The string attributes equal
+// to an exported identifier are considered as references, and are replaced by the new
+// identifier. If the referenced object is created after the referencing one, the attribute
+// is set by saving the referencing object once all the objects are created. The assigned
+// children are found by path among the imported Identifiables, or by natural key with Resolve.
+func (i *Importer) Import(parent Identifiable, exported *ExportedObject) (map[string]string, *Error) {
+
+	state := &importState{
+		identifiers: map[string]string{},
+		exported:    map[string]bool{},
+		paths:       map[string]Identifiable{},
+		created:     map[*ExportedObject]Identifiable{},
+	}
+
+	state.collect(exported)
+
+	if berr := i.create(state, parent, "", exported); berr != nil {
+		return nil, berr
+	}
+
+	for _, d := range state.deferred {
+		if berr := i.update(state, d.object, d.attributes); berr != nil {
+			return nil, berr
+		}
+	}
+
+	if berr := i.assign(state, exported); berr != nil {
+		return nil, berr
+	}
+
+	return state.identifiers, nil
+}
+
+// importState contains the Identifiables created by a call to Import.
+type importState struct {
+	identifiers map[string]string
+	exported    map[string]bool
+	paths       map[string]Identifiable
+	created     map[*ExportedObject]Identifiable
+	deferred    []*deferredAttributes
+}
+
+// deferredAttributes represents the attributes of an imported Identifiable that
+// reference objects that were not yet imported when it was created.
+type deferredAttributes struct {
+	object     Identifiable
+	attributes map[string]interface{}
+}
+
+// collect collects the identifiers of the given ExportedObject and its descendants.
+func (s *importState) collect(exported *ExportedObject) {
+
+	if exported.ID != "" {
+		s.exported[exported.ID] = true
+	}
+
+	for _, child := range exported.Children {
+		s.collect(child)
+	}
+}
+
+// remap returns the given attribute value with the exported identifiers replaced by the identifiers
+// of the imported objects. It returns false if an exported identifier has not been imported yet.
+func (s *importState) remap(value interface{}) (interface{}, bool) {
+
+	switch v := value.(type) {
+
+	case string:
+		if !s.exported[v] {
+			return v, true
+		}
+		ID, ok := s.identifiers[v]
+		return ID, ok
+
+	case []interface{}:
+		remapped := make([]interface{}, len(v))
+		complete := true
+		for n, item := range v {
+			var ok bool
+			remapped[n], ok = s.remap(item)
+			complete = complete && ok
+		}
+		return remapped, complete
+
+	case map[string]interface{}:
+		remapped := make(map[string]interface{}, len(v))
+		complete := true
+		for key, item := range v {
+			var ok bool
+			remapped[key], ok = s.remap(item)
+			complete = complete && ok
+		}
+		return remapped, complete
+
+	default:
+		return v, true
+	}
+}
+
+// create creates the given ExportedObject and its descendants under the given parent with the given path.
+func (i *Importer) create(state *importState, parent Identifiable, parentPath string, exported *ExportedObject) *Error {
+
+	object := DefaultIdentityRegistry.NewFromName(exported.Identity)
+	if object == nil {
+		return NewBambouError("Import error", fmt.Sprintf("No registered identity named %s", exported.Identity))
+	}
+
+	readOnly := i.ReadOnlyAttributes
+	if readOnly == nil {
+		readOnly = readOnlyAttributesOf(exported.Identity)
+	}
+
+	attributes := make(map[string]interface{}, len(exported.Attributes))
+	for name, value := range exported.Attributes {
+		attributes[name] = value
+	}
+
+	for _, name := range readOnly {
+		delete(attributes, name)
+	}
+
+	deferred := map[string]interface{}{}
+	for name, value := range attributes {
+
+		remapped, complete := state.remap(value)
+		if !complete {
+			deferred[name] = value
+			delete(attributes, name)
+			continue
+		}
+
+		attributes[name] = remapped
+	}
+
+	if berr := decodeAttributes(attributes, object); berr != nil {
+		return berr
+	}
+
+	if berr := i.storer.CreateChild(parent, object); berr != nil {
+		return berr
+	}
+
+	if exported.ID != "" {
+		state.identifiers[exported.ID] = object.Identifier()
+	}
+
+	if len(deferred) > 0 {
+		state.deferred = append(state.deferred, &deferredAttributes{object: object, attributes: deferred})
+	}
+
+	path := describeKey(exported.Identity, naturalKey(object, i.KeyFunc))
+	if parentPath != "" {
+		path = parentPath + "/" + path
+	}
+
+	state.paths[path] = object
+	state.created[exported] = object
+
+	for _, child := range exported.Children {
+		if berr := i.create(state, object, path, child); berr != nil {
+			return berr
+		}
+	}
+
+	return nil
+}
+
+// update sets the given deferred attributes of the given imported Identifiable and saves it.
+func (i *Importer) update(state *importState, object Identifiable, attributes map[string]interface{}) *Error {
+
+	for name, value := range attributes {
+		attributes[name], _ = state.remap(value)
+	}
+
+	if berr := decodeAttributes(attributes, object); berr != nil {
+		return berr
+	}
+
+	return i.storer.SaveEntity(object)
+}
+
+// assign assigns the children of the given created ExportedObject and its descendants.
+func (i *Importer) assign(state *importState, exported *ExportedObject) *Error {
+
+	object := state.created[exported]
+
+	for _, assignment := range exported.Assignments {
+
+		identity, ok := DefaultIdentityRegistry.IdentityFromName(assignment.Identity)
+		if !ok {
+			return NewBambouError("Import error", fmt.Sprintf("No registered identity named %s", assignment.Identity))
+		}
+
+		var children []Identifiable
+
+		for _, path := range assignment.Paths {
+
+			child, ok := state.paths[path]
+			if !ok {
+				return NewBambouError("Import error", fmt.Sprintf("Unable to find the imported %s to assign", path))
+			}
+
+			children = append(children, child)
+		}
+
+		for _, key := range assignment.Keys {
+
+			child, berr := i.resolve(identity, key)
+			if berr != nil {
+				return berr
+			}
+
+			children = append(children, child)
+		}
+
+		if berr := i.storer.AssignChildren(object, children, identity); berr != nil {
+			return berr
+		}
+	}
+
+	for _, child := range exported.Children {
+		if berr := i.assign(state, child); berr != nil {
+			return berr
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the Identifiable with the given Identity and natural key using Resolve.
+func (i *Importer) resolve(identity Identity, key string) (Identifiable, *Error) {
+
+	if i.Resolve != nil {
+		child, berr := i.Resolve(identity, key)
+		if berr != nil {
+			return nil, berr
+		}
+		if child != nil {
+			return child, nil
+		}
+	}
+
+	return nil, NewBambouError("Import error", fmt.Sprintf("Unable to find the %s %q to assign", identity.Name, key))
+}
+
+// decodeAttributes sets the given attributes of the given Identifiable.
+func decodeAttributes(attributes map[string]interface{}, object Identifiable) *Error {
+
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return NewBambouError("JSON error", err.Error())
+	}
+
+	if err := json.Unmarshal(data, object); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return nil
+}
+
+// WriteExport writes the given ExportedObject to the given io.Writer in the given ExportFormat.
+func WriteExport(w io.Writer, exported *ExportedObject, format ExportFormat) *Error {
+
+	var data []byte
+	var err error
+
+	switch format {
+	case ExportFormatJSON:
+		data, err = json.MarshalIndent(exported, "", "    ")
+	case ExportFormatYAML:
+		data, err = yaml.Marshal(exported)
+	default:
+		return NewBambouError("Export error", fmt.Sprintf("Unsupported format %s", format))
+	}
+
+	if err != nil {
+		return NewBambouError("Export error", err.Error())
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return NewBambouError("Export error", err.Error())
+	}
+
+	return nil
+}
+
+// ReadExport reads an ExportedObject from the given io.Reader in the given ExportFormat.
+func ReadExport(r io.Reader, format ExportFormat) (*ExportedObject, *Error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, NewBambouError("Import error", err.Error())
+	}
+
+	exported := &ExportedObject{}
+
+	switch format {
+	case ExportFormatJSON:
+		err = json.Unmarshal(data, exported)
+	case ExportFormatYAML:
+		if err = yaml.Unmarshal(data, exported); err == nil {
+			normalizeYAMLAttributes(exported)
+		}
+	default:
+		return nil, NewBambouError("Import error", fmt.Sprintf("Unsupported format %s", format))
+	}
+
+	if err != nil {
+		return nil, NewBambouError("Import error", err.Error())
+	}
+
+	return exported, nil
+}
+
+// SaveExport writes the given ExportedObject to the file at the given path.
+// The file is written in YAML if its extension is .yaml or .yml, in JSON otherwise.
+func SaveExport(path string, exported *ExportedObject) *Error {
+
+	buffer := &bytes.Buffer{}
+	if berr := WriteExport(buffer, exported, exportFormatFromPath(path)); berr != nil {
+		return berr
+	}
+
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0600); err != nil {
+		return NewBambouError("Export error", err.Error())
+	}
+
+	return nil
+}
+
+// LoadExport reads an ExportedObject from the file at the given path.
+// The file is read as YAML if its extension is .yaml or .yml, as JSON otherwise.
+func LoadExport(path string) (*ExportedObject, *Error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewBambouError("Import error", err.Error())
+	}
+
+	return ReadExport(bytes.NewReader(data), exportFormatFromPath(path))
+}
+
+// exportFormatFromPath returns the ExportFormat matching the extension of the given path.
+func exportFormatFromPath(path string) ExportFormat {
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ExportFormatYAML
+	default:
+		return ExportFormatJSON
+	}
+}
+
+// normalizeYAMLAttributes converts the attributes of the given ExportedObject and its descendants
+// decoded from YAML into values that can be encoded in JSON.
+func normalizeYAMLAttributes(exported *ExportedObject) {
+
+	for name, value := range exported.Attributes {
+		exported.Attributes[name] = normalizeYAMLValue(value)
+	}
+
+	for _, child := range exported.Children {
+		normalizeYAMLAttributes(child)
+	}
+}
+
+// normalizeYAMLValue converts the maps decoded from YAML into maps with string keys.
+func normalizeYAMLValue(value interface{}) interface{} {
+
+	switch v := value.(type) {
+
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAMLValue(item)
+		}
+		return m
+
+	case []interface{}:
+		for n, item := range v {
+			v[n] = normalizeYAMLValue(item)
+		}
+		return v
+
+	default:
+		return v
+	}
+}
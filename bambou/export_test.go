@@ -0,0 +1,385 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newExportedTree returns a MemoryStorer containing fakes owning things, with a fake assigned to a thing.
+func newExportedTree() (*FakeRootObject, *MemoryStorer, *FakeObject) {
+
+	r := NewFakeRootObject()
+	m := NewMemoryStorer(r)
+
+	a := &FakeObject{ID: "a", Name: "alpha"}
+	b := &FakeObject{ID: "b", Name: "beta"}
+	m.CreateChild(r, a)
+	m.CreateChild(a, b)
+
+	one := &thing{ID: "t1", Name: "one", Description: "first"}
+	m.CreateChild(a, one)
+	m.CreateChild(a, &thing{ID: "t2", Name: "two"})
+	m.AssignChildren(one, []Identifiable{b}, FakeIdentity)
+
+	return r, m, a
+}
+
+func TestExport_Export(t *testing.T) {
+
+	Convey("Given I have a tree of objects and an Exporter", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		RegisterIdentity(thingIdentity, func() Identifiable { return &thing{} })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+		defer DefaultIdentityRegistry.Unregister(thingIdentity)
+
+		_, m, a := newExportedTree()
+
+		e := NewExporter(m, map[string][]Identity{FakeIdentity.Name: {FakeIdentity, thingIdentity}})
+		e.Assignments = map[string][]Identity{thingIdentity.Name: {FakeIdentity}}
+
+		Convey("When I export an object", func() {
+
+			exported, err := e.Export(context.Background(), a)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the object should be exported", func() {
+				So(exported.Identity, ShouldEqual, "fake")
+				So(exported.ID, ShouldEqual, "a")
+				So(exported.Attributes, ShouldResemble, map[string]interface{}{"name": "alpha"})
+			})
+
+			Convey("Then the descendants should be exported", func() {
+				So(len(exported.Children), ShouldEqual, 3)
+				So(exported.Children[0].Attributes["name"], ShouldEqual, "beta")
+				So(exported.Children[1].Attributes, ShouldResemble, map[string]interface{}{"name": "one", "description": "first"})
+				So(exported.Children[2].Attributes["name"], ShouldEqual, "two")
+			})
+
+			Convey("Then the assignments should be exported by path", func() {
+				So(exported.Children[1].Assignments, ShouldResemble, []*ExportedAssignment{{Identity: "fake", Paths: []string{`fake "alpha"/fake "beta"`}}})
+				So(exported.Children[2].Assignments, ShouldBeEmpty)
+			})
+
+			Convey("When I import it under another parent", func() {
+
+				r2 := NewFakeRootObject()
+				m2 := NewMemoryStorer(r2)
+
+				identifiers, err := NewImporter(m2).Import(r2, exported)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the objects should have new identifiers", func() {
+					So(len(identifiers), ShouldEqual, 4)
+					So(identifiers["a"], ShouldNotEqual, "a")
+					So(identifiers["t1"], ShouldNotEqual, "t1")
+				})
+
+				Convey("Then the objects should be created", func() {
+					o := NewFakeObject(identifiers["a"])
+					So(m2.FetchEntity(o), ShouldBeNil)
+					So(o.Name, ShouldEqual, "alpha")
+
+					var things []*thing
+					m2.FetchChildren(o, thingIdentity, &things, nil)
+					So(len(things), ShouldEqual, 2)
+					So(things[0].Description, ShouldEqual, "first")
+				})
+
+				Convey("Then the assignments should use the new identifiers", func() {
+					var fakes []*FakeObject
+					m2.FetchChildren(&thing{ID: identifiers["t1"]}, FakeIdentity, &fakes, nil)
+					So(len(fakes), ShouldEqual, 1)
+					So(fakes[0].ID, ShouldEqual, identifiers["b"])
+				})
+			})
+		})
+
+		Convey("When I export and import an object with the registered schemas", func() {
+
+			RegisterIdentitySchema(FakeIdentity, &IdentitySchema{Children: []Relationship{{Identity: FakeIdentity}, {Identity: thingIdentity}}})
+			RegisterIdentitySchema(thingIdentity, &IdentitySchema{
+				Children:   []Relationship{{Identity: FakeIdentity, Member: true}},
+				Attributes: []AttributeSchema{{Name: "description", ReadOnly: true}},
+			})
+
+			exported, err1 := NewExporter(m, nil).Export(context.Background(), a)
+
+			r2 := NewFakeRootObject()
+			m2 := NewMemoryStorer(r2)
+			identifiers, err2 := NewImporter(m2).Import(r2, exported)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the children and assignments of the schemas should be exported", func() {
+				So(len(exported.Children), ShouldEqual, 3)
+				So(exported.Children[1].Assignments, ShouldResemble, []*ExportedAssignment{{Identity: "fake", Paths: []string{`fake "alpha"/fake "beta"`}}})
+			})
+
+			Convey("Then the read-only attributes of the schemas should not be imported", func() {
+				o := &thing{ID: identifiers["t1"]}
+				m2.FetchEntity(o)
+				So(o.Name, ShouldEqual, "one")
+				So(o.Description, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I import a document with read-only attributes", func() {
+
+			r2 := NewFakeRootObject()
+			m2 := NewMemoryStorer(r2)
+
+			i := NewImporter(m2)
+			i.ReadOnlyAttributes = []string{"ID", "description"}
+
+			identifiers, err := i.Import(r2, &ExportedObject{
+				Identity:   "thing",
+				ID:         "old",
+				Attributes: map[string]interface{}{"ID": "old", "name": "n", "description": "d"},
+			})
+
+			Convey("Then the read-only attributes should be skipped", func() {
+				So(err, ShouldBeNil)
+				o := &thing{ID: identifiers["old"]}
+				m2.FetchEntity(o)
+				So(o.Name, ShouldEqual, "n")
+				So(o.Description, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I import a document assigning an unknown object", func() {
+
+			r2 := NewFakeRootObject()
+			m2 := NewMemoryStorer(r2)
+			m2.CreateChild(r2, &FakeObject{ID: "existing", Name: "existing"})
+
+			exported := &ExportedObject{
+				Identity:    "thing",
+				Attributes:  map[string]interface{}{"name": "n"},
+				Assignments: []*ExportedAssignment{{Identity: "fake", Keys: []string{"existing"}}},
+			}
+
+			Convey("When the Importer cannot resolve it", func() {
+
+				_, err := NewImporter(m2).Import(r2, exported)
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Title, ShouldEqual, "Import error")
+				})
+			})
+
+			Convey("When the Importer resolves it", func() {
+
+				i := NewImporter(m2)
+				i.Resolve = func(identity Identity, key string) (Identifiable, *Error) {
+					return &FakeObject{ID: key}, nil
+				}
+
+				_, err := i.Import(r2, exported)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When I export and import objects with the same name under different parents", func() {
+
+			p := &FakeObject{ID: "fp", Name: "p"}
+			q := &FakeObject{ID: "fq", Name: "q"}
+			dp := &thing{ID: "dp", Name: "default"}
+			dq := &thing{ID: "dq", Name: "default"}
+			m.CreateChild(a, p)
+			m.CreateChild(a, q)
+			m.CreateChild(p, dp)
+			m.CreateChild(q, dq)
+			m.AssignChildren(dq, []Identifiable{dp}, thingIdentity)
+
+			e := NewExporter(m, map[string][]Identity{FakeIdentity.Name: {FakeIdentity, thingIdentity}})
+			e.Assignments = map[string][]Identity{thingIdentity.Name: {thingIdentity}}
+
+			exported, err1 := e.Export(context.Background(), a)
+
+			r2 := NewFakeRootObject()
+			m2 := NewMemoryStorer(r2)
+			identifiers, err2 := NewImporter(m2).Import(r2, exported)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the assigned object should be the one under the same parent", func() {
+				var things []*thing
+				m2.FetchChildren(&thing{ID: identifiers["dq"]}, thingIdentity, &things, nil)
+				So(len(things), ShouldEqual, 1)
+				So(things[0].ID, ShouldEqual, identifiers["dp"])
+			})
+		})
+
+		Convey("When I export assigned objects with the same path", func() {
+
+			d1 := &thing{ID: "d1", Name: "default"}
+			d2 := &thing{ID: "d2", Name: "default"}
+			m.CreateChild(a, d1)
+			m.CreateChild(a, d2)
+			m.AssignChildren(d2, []Identifiable{d1}, thingIdentity)
+
+			e := NewExporter(m, map[string][]Identity{FakeIdentity.Name: {thingIdentity}})
+			e.Assignments = map[string][]Identity{thingIdentity.Name: {thingIdentity}}
+
+			_, err := e.Export(context.Background(), a)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Export error")
+			})
+		})
+
+		Convey("When I export and import objects referencing other objects by ID", func() {
+
+			m.SaveEntity(&thing{ID: "t1", Name: "one", Description: "t2"})
+			m.SaveEntity(&thing{ID: "t2", Name: "two", Description: "b"})
+
+			exported, err1 := NewExporter(m, map[string][]Identity{FakeIdentity.Name: {FakeIdentity, thingIdentity}}).Export(context.Background(), a)
+
+			r2 := NewFakeRootObject()
+			m2 := NewMemoryStorer(r2)
+			identifiers, err2 := NewImporter(m2).Import(r2, exported)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the references to objects created before should be remapped", func() {
+				o := &thing{ID: identifiers["t2"]}
+				m2.FetchEntity(o)
+				So(o.Description, ShouldEqual, identifiers["b"])
+			})
+
+			Convey("Then the references to objects created after should be remapped", func() {
+				o := &thing{ID: identifiers["t1"]}
+				m2.FetchEntity(o)
+				So(o.Name, ShouldEqual, "one")
+				So(o.Description, ShouldEqual, identifiers["t2"])
+			})
+		})
+
+		Convey("When I import a document with an unknown identity", func() {
+
+			_, err := NewImporter(m).Import(a, &ExportedObject{Identity: "unknown"})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestExport_Files(t *testing.T) {
+
+	Convey("Given I have an exported object", t, func() {
+
+		exported := &ExportedObject{
+			Identity: "fake",
+			ID:       "a",
+			Attributes: map[string]interface{}{
+				"name":    "alpha",
+				"options": map[string]interface{}{"enabled": true, "tags": []interface{}{"x"}},
+			},
+			Children:    []*ExportedObject{{Identity: "thing", Attributes: map[string]interface{}{"name": "one"}}},
+			Assignments: []*ExportedAssignment{{Identity: "fake", Paths: []string{`fake "alpha"/thing "one"`}, Keys: []string{"beta"}}},
+		}
+
+		dir, _ := ioutil.TempDir("", "bambou")
+		defer os.RemoveAll(dir)
+
+		for _, name := range []string{"export.json", "export.yaml", "export.yml"} {
+
+			path := filepath.Join(dir, name)
+
+			Convey("When I save and load it as "+name, func() {
+
+				err1 := SaveExport(path, exported)
+				loaded, err2 := LoadExport(path)
+
+				Convey("Then the errors should be nil", func() {
+					So(err1, ShouldBeNil)
+					So(err2, ShouldBeNil)
+				})
+
+				Convey("Then the loaded object should be the same", func() {
+					So(loaded, ShouldResemble, exported)
+				})
+			})
+		}
+
+		Convey("When I write it as YAML", func() {
+
+			buffer := &bytes.Buffer{}
+			WriteExport(buffer, exported, ExportFormatYAML)
+
+			Convey("Then the document should be YAML", func() {
+				So(buffer.String(), ShouldStartWith, "identity: fake\nID: a\n")
+			})
+		})
+
+		Convey("When I write it in an unsupported format", func() {
+
+			err := WriteExport(&bytes.Buffer{}, exported, ExportFormat("xml"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I load a missing file", func() {
+
+			_, err := LoadExport(filepath.Join(dir, "missing.json"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
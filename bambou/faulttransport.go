@@ -0,0 +1,313 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FaultKind represents the kind of fault injected by a FaultTransport.
+type FaultKind int
+
+// Supported values for FaultKind.
+const (
+	// FaultLatency delays the request by the Latency of the rule.
+	FaultLatency FaultKind = iota
+
+	// FaultServerError answers with the StatusCode of the rule,
+	// or a random 5xx status code if it is not set.
+	FaultServerError
+
+	// FaultConnectionReset fails the request as if the connection was reset.
+	FaultConnectionReset
+
+	// FaultTruncatedBody sends the request and truncates the body of the response.
+	FaultTruncatedBody
+
+	// FaultTokenExpired answers with 401 Unauthorized, like the server does
+	// when the API key has expired.
+	FaultTokenExpired
+
+	// FaultMultipleChoices answers with 300 Multiple Choices.
+	FaultMultipleChoices
+)
+
+// String returns the string representation of the FaultKind.
+func (k FaultKind) String() string {
+
+	switch k {
+	case FaultLatency:
+		return "latency"
+	case FaultServerError:
+		return "server error"
+	case FaultConnectionReset:
+		return "connection reset"
+	case FaultTruncatedBody:
+		return "truncated body"
+	case FaultTokenExpired:
+		return "token expired"
+	case FaultMultipleChoices:
+		return "multiple choices"
+	default:
+		return fmt.Sprintf("unknown fault %d", int(k))
+	}
+}
+
+// FaultRule describes which requests a FaultTransport alters and how.
+type FaultRule struct {
+
+	// Kind is the kind of fault to inject.
+	Kind FaultKind
+
+	// Method is the HTTP method of the matched requests. If empty, all methods match.
+	Method string
+
+	// Identity is the Identity of the matched requests, compared using its
+	// Category with the last part of the URL, like "/enterprises" or
+	// "/enterprises/xxx". Use the Category "events" for push notifications.
+	// If empty, all requests match.
+	Identity Identity
+
+	// Probability is the probability between 0 and 1 to inject the fault in a
+	// matched request. If zero, the fault is always injected.
+	Probability float64
+
+	// MaxFaults is the maximum number of times the fault is injected.
+	// If zero, there is no limit.
+	MaxFaults int
+
+	// Latency is the delay added by a FaultLatency rule.
+	Latency time.Duration
+
+	// StatusCode is the status code returned by a FaultServerError rule.
+	StatusCode int
+}
+
+// faultRule is a FaultRule with the number of times it has been applied.
+type faultRule struct {
+	FaultRule
+	count int
+}
+
+// matches returns true if the rule applies to the given request.
+func (r *faultRule) matches(request *http.Request) bool {
+
+	if r.MaxFaults > 0 && r.count >= r.MaxFaults {
+		return false
+	}
+
+	if r.Method != "" && !strings.EqualFold(r.Method, request.Method) {
+		return false
+	}
+
+	if r.Identity.Category == "" {
+		return true
+	}
+
+	segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	n := len(segments)
+
+	return segments[n-1] == r.Identity.Category || (n > 1 && segments[n-2] == r.Identity.Category)
+}
+
+// serverErrorCodes are the status codes used by FaultServerError rules without StatusCode.
+var serverErrorCodes = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// FaultTransport is an http.RoundTripper injecting faults in the requests
+// matching its rules. It is meant to test how the users of a Session behave
+// when the server misbehaves:
+//
+//	t := bambou.NewFaultTransport(session.Transport(), 42)
+//	t.AddRule(bambou.FaultRule{Kind: bambou.FaultServerError, Method: "GET", Probability: 0.1})
+//	session.SetTransport(t)
+//
+// The rules are evaluated in the order they have been added. Latency rules
+// are cumulative, the other rules stop the evaluation.
+// Using the same seed and the same sequence of requests produces the same faults.
+type FaultTransport struct {
+	transport http.RoundTripper
+	rules     []*faultRule
+	random    *rand.Rand
+	lock      sync.Mutex
+}
+
+// NewFaultTransport returns a new *FaultTransport wrapping the given http.RoundTripper
+// and using the given seed for its random decisions. If transport is nil,
+// http.DefaultTransport is used.
+func NewFaultTransport(transport http.RoundTripper, seed int64) *FaultTransport {
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &FaultTransport{
+		transport: transport,
+		random:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// AddRule adds the given FaultRule.
+func (t *FaultTransport) AddRule(rule FaultRule) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.rules = append(t.rules, &faultRule{FaultRule: rule})
+}
+
+// ClearRules removes all the rules.
+func (t *FaultTransport) ClearRules() {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.rules = nil
+}
+
+// RoundTrip executes the given request, injecting the faults of the matching rules.
+func (t *FaultTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+
+	latency, fault := t.faultsFor(request)
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-request.Context().Done():
+			closeRequestBody(request)
+			return nil, request.Context().Err()
+		}
+	}
+
+	if fault == nil {
+		return t.transport.RoundTrip(request)
+	}
+
+	switch fault.Kind {
+
+	case FaultConnectionReset:
+		closeRequestBody(request)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	case FaultTruncatedBody:
+		response, err := t.transport.RoundTrip(request)
+		if err != nil {
+			return response, err
+		}
+		defer response.Body.Close()
+
+		body, _ := ioutil.ReadAll(response.Body)
+		body = body[:len(body)/2]
+
+		response.Body = ioutil.NopCloser(bytes.NewReader(body))
+		response.ContentLength = int64(len(body))
+		response.Header.Del("Content-Length")
+
+		return response, nil
+
+	case FaultTokenExpired:
+		return newFaultResponse(request, http.StatusUnauthorized), nil
+
+	case FaultMultipleChoices:
+		return newFaultResponse(request, http.StatusMultipleChoices), nil
+
+	default:
+		return newFaultResponse(request, fault.StatusCode), nil
+	}
+}
+
+// faultsFor returns the total latency and the fault to inject for the given request.
+func (t *FaultTransport) faultsFor(request *http.Request) (time.Duration, *FaultRule) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var latency time.Duration
+
+	for _, rule := range t.rules {
+
+		if !rule.matches(request) {
+			continue
+		}
+
+		if rule.Probability > 0 && t.random.Float64() >= rule.Probability {
+			continue
+		}
+
+		rule.count++
+
+		if rule.Kind == FaultLatency {
+			latency += rule.Latency
+			continue
+		}
+
+		fault := rule.FaultRule
+		if fault.Kind == FaultServerError && fault.StatusCode == 0 {
+			fault.StatusCode = serverErrorCodes[t.random.Intn(len(serverErrorCodes))]
+		}
+
+		return latency, &fault
+	}
+
+	return latency, nil
+}
+
+// newFaultResponse returns an empty *http.Response with the given status code.
+func newFaultResponse(request *http.Request, code int) *http.Response {
+
+	closeRequestBody(request)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(nil)),
+		ContentLength: 0,
+		Request:       request,
+	}
+}
+
+// closeRequestBody closes the body of the given request, as a RoundTripper must do.
+func closeRequestBody(request *http.Request) {
+
+	if request.Body != nil {
+		request.Body.Close()
+	}
+}
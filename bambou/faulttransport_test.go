@@ -0,0 +1,242 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFaultTransport_FaultKind(t *testing.T) {
+
+	Convey("Given I have some fault kinds", t, func() {
+
+		Convey("Then their string representation should be correct", func() {
+			So(FaultServerError.String(), ShouldEqual, "server error")
+			So(FaultKind(42).String(), ShouldEqual, "unknown fault 42")
+		})
+	})
+}
+
+func TestFaultTransport_RoundTrip(t *testing.T) {
+
+	Convey("Given I have a Session using a FaultTransport", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello"}]`)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		transport := NewFaultTransport(session.Transport(), 42)
+		session.SetTransport(transport)
+
+		Convey("Then the transport of the session should be the FaultTransport", func() {
+			So(session.Transport(), ShouldEqual, transport)
+		})
+
+		Convey("When I fetch an entity without rules", func() {
+
+			o := NewFakeObject("xxx")
+			err := session.FetchEntity(o)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the object should be fetched", func() {
+				So(o.Name, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When I add a server error rule and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultServerError})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should have a 5xx code", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldBeBetweenOrEqual, 500, 599)
+			})
+		})
+
+		Convey("When I add a server error rule with a status code and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultServerError, StatusCode: http.StatusServiceUnavailable})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should have the given code", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+
+		Convey("When I add a token expired rule for another identity and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultTokenExpired, Identity: Identity{"other", "others"}})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I add a token expired rule for another method and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultTokenExpired, Method: "PUT"})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I add a token expired rule for the identity and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultTokenExpired, Method: "GET", Identity: FakeIdentity})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should have the code 401", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+
+		Convey("When I add a connection reset rule and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultConnectionReset})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be a client error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "HTTP client error")
+			})
+		})
+
+		Convey("When I add a truncated body rule and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultTruncatedBody})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be an unmarshalling error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "JSON unmarshalling error")
+			})
+		})
+
+		Convey("When I add a multiple choices rule injected once and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultMultipleChoices, MaxFaults: 1})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I add a multiple choices rule always injected and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultMultipleChoices})
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should have the code 300", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusMultipleChoices)
+			})
+		})
+
+		Convey("When I add a latency rule and fetch an entity", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultLatency, Latency: 50 * time.Millisecond})
+			start := time.Now()
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the request should have been delayed", func() {
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			})
+		})
+
+		Convey("When I add a rule and clear the rules", func() {
+
+			transport.AddRule(FaultRule{Kind: FaultServerError})
+			transport.ClearRules()
+			err := session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestFaultTransport_Seed(t *testing.T) {
+
+	Convey("Given I have a server and two FaultTransports with the same seed", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"ID": "xxx"}]`)
+		}))
+		defer ts.Close()
+
+		run := func() []bool {
+
+			session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+			transport := NewFaultTransport(nil, 7)
+			transport.AddRule(FaultRule{Kind: FaultServerError, Probability: 0.5})
+			session.SetTransport(transport)
+
+			var results []bool
+			for i := 0; i < 20; i++ {
+				results = append(results, session.FetchEntity(NewFakeObject("xxx")) == nil)
+			}
+
+			return results
+		}
+
+		Convey("When I send the same requests", func() {
+
+			r1 := run()
+			r2 := run()
+
+			Convey("Then the faults should be the same", func() {
+				So(r1, ShouldResemble, r2)
+			})
+
+			Convey("Then some requests should have failed and some should have succeeded", func() {
+				So(r1, ShouldContain, true)
+				So(r1, ShouldContain, false)
+			})
+		})
+	})
+}
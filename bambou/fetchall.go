@@ -0,0 +1,120 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// fetchAllPageSize is the number of children requested per page by FetchAllChildren.
+const fetchAllPageSize = 500
+
+// FetchAllChildren fetches all the children with the given Identity of the given parent,
+// requesting as many pages as needed. The children are instantiated from the
+// DefaultIdentityRegistry, so the Identity must be registered.
+func FetchAllChildren(storer Storer, parent Identifiable, identity Identity) (IdentifiablesList, *Error) {
+
+	if DefaultIdentityRegistry.New(identity) == nil {
+		return nil, NewBambouError("Fetching error", fmt.Sprintf("No registered identity named %s", identity.Name))
+	}
+
+	items, berr := fetchAllRaw(storer, parent, identity)
+	if berr != nil {
+		return nil, berr
+	}
+
+	children := make(IdentifiablesList, len(items))
+
+	for i, item := range items {
+
+		child := DefaultIdentityRegistry.New(identity)
+		if err := json.Unmarshal(item, child); err != nil {
+			return nil, NewBambouError("JSON unmarshalling error", err.Error())
+		}
+
+		children[i] = child
+	}
+
+	return children, nil
+}
+
+// fetchAllRaw fetches the JSON representation of all the children with the given
+// Identity of the given parent, requesting pages until the total count returned
+// by the Storer is reached or, without total count, until a page is not full.
+func fetchAllRaw(storer Storer, parent Identifiable, identity Identity) ([]json.RawMessage, *Error) {
+
+	all := []json.RawMessage{}
+
+	for page := 0; ; page++ {
+
+		info := &FetchingInfo{
+			Page:     page,
+			PageSize: fetchAllPageSize,
+		}
+
+		var items []json.RawMessage
+		if berr := storer.FetchChildren(parent, identity, &items, info); berr != nil {
+			return nil, berr
+		}
+
+		all = append(all, items...)
+
+		// The server may return smaller pages than requested, so its count is trusted when given
+		if len(items) == 0 || (info.TotalCount > 0 && len(all) >= info.TotalCount) {
+			return all, nil
+		}
+
+		if info.TotalCount <= 0 && len(items) < fetchAllPageSize {
+			return all, nil
+		}
+	}
+}
+
+// fetchAllIdentifiers fetches the identifiers of all the children with the given
+// Identity of the given parent.
+func fetchAllIdentifiers(storer Storer, parent Identifiable, identity Identity) ([]string, *Error) {
+
+	items, berr := fetchAllRaw(storer, parent, identity)
+	if berr != nil {
+		return nil, berr
+	}
+
+	identifiers := make([]string, len(items))
+
+	for i, item := range items {
+
+		var object struct {
+			ID string `json:"ID"`
+		}
+
+		if err := json.Unmarshal(item, &object); err != nil {
+			return nil, NewBambouError("JSON unmarshalling error", err.Error())
+		}
+
+		identifiers[i] = object.ID
+	}
+
+	return identifiers, nil
+}
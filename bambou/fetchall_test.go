@@ -0,0 +1,117 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// cappedStorer is a MemoryStorer returning at most 50 children per page.
+type cappedStorer struct {
+	*MemoryStorer
+}
+
+func (s *cappedStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	if info != nil && info.PageSize > 50 {
+		info.PageSize = 50
+	}
+
+	return s.MemoryStorer.FetchChildren(parent, identity, dest, info)
+}
+
+func TestFetchAll_FetchAllChildren(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer with more children than a page", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		for i := 0; i < fetchAllPageSize+1; i++ {
+			m.CreateChild(r, NewFakeObject(fmt.Sprintf("%d", i)))
+		}
+
+		Convey("When I fetch all the children", func() {
+
+			children, err := FetchAllChildren(m, r, FakeIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get all the children", func() {
+				So(len(children), ShouldEqual, fetchAllPageSize+1)
+				So(children[0], ShouldHaveSameTypeAs, &FakeObject{})
+				So(children[fetchAllPageSize].Identifier(), ShouldEqual, fmt.Sprintf("%d", fetchAllPageSize))
+			})
+		})
+
+		Convey("When I fetch all the children from a Storer returning smaller pages", func() {
+
+			children, err := FetchAllChildren(&cappedStorer{MemoryStorer: m}, r, FakeIdentity)
+
+			Convey("Then I should get all the children", func() {
+				So(err, ShouldBeNil)
+				So(len(children), ShouldEqual, fetchAllPageSize+1)
+			})
+		})
+
+		Convey("When I fetch all the children of an unregistered identity", func() {
+
+			_, err := FetchAllChildren(m, r, Identity{"other", "others"})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a MemoryStorer without children", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		Convey("When I fetch all the children", func() {
+
+			children, err := FetchAllChildren(m, r, FakeIdentity)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get no children", func() {
+				So(len(children), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlightGroup_Do(t *testing.T) {
+
+	Convey("Given I have a flightGroup", t, func() {
+
+		g := &flightGroup{}
+		var calls int32
+		release := make(chan struct{})
+
+		fn := func() (*flightResponse, *Error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return &flightResponse{body: []byte("hello")}, nil
+		}
+
+		Convey("When I do the same call concurrently", func() {
+
+			wg := &sync.WaitGroup{}
+			shared := make(chan bool, 5)
+			bodies := make(chan string, 5)
+
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					response, _, s := g.do("key", fn)
+					shared <- s
+					bodies <- string(response.body)
+				}()
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(shared)
+			close(bodies)
+
+			sharedCount := 0
+			for s := range shared {
+				if s {
+					sharedCount++
+				}
+			}
+
+			Convey("Then the function should have been called once", func() {
+				So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			})
+
+			Convey("Then the other calls should have shared the result", func() {
+				So(sharedCount, ShouldEqual, 4)
+				for body := range bodies {
+					So(body, ShouldEqual, "hello")
+				}
+			})
+
+			Convey("When I do the call again", func() {
+
+				_, _, s := g.do("key", fn)
+
+				Convey("Then the function should be called again", func() {
+					So(s, ShouldBeFalse)
+					So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When the call fails and is shared", func() {
+
+			failing := func() (*flightResponse, *Error) {
+				<-release
+				return nil, NewError(500, "boom")
+			}
+
+			errs := make(chan *Error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, berr, _ := g.do("key", failing)
+					errs <- berr
+				}()
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			err1, err2 := <-errs, <-errs
+
+			Convey("Then both calls should get a copy of the error", func() {
+				So(err1, ShouldResemble, err2)
+				So(err1, ShouldNotPointTo, err2)
+				So(err1.Code, ShouldEqual, 500)
+			})
+		})
+	})
+}
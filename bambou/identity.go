@@ -74,3 +74,24 @@ var AllIdentity = Identity{
 	Name:     "__all__",
 	Category: "__all__",
 }
+
+// identifiableReference is an Identifiable only known by its Identity and identifier.
+type identifiableReference struct {
+	identity   Identity
+	identifier string
+}
+
+// newIdentifiableReferences returns the references of the given identifiers with the given Identity.
+func newIdentifiableReferences(identity Identity, identifiers []string) []Identifiable {
+
+	references := make([]Identifiable, len(identifiers))
+	for i, identifier := range identifiers {
+		references[i] = &identifiableReference{identity: identity, identifier: identifier}
+	}
+
+	return references
+}
+
+func (o *identifiableReference) Identity() Identity      { return o.identity }
+func (o *identifiableReference) Identifier() string      { return o.identifier }
+func (o *identifiableReference) SetIdentifier(ID string) { o.identifier = ID }
@@ -0,0 +1,296 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"sort"
+	"sync"
+)
+
+// IdentifiableFactory is the prototype of a function returning a new Identifiable.
+type IdentifiableFactory func() Identifiable
+
+// identityRegistration represents an Identity registered with its IdentifiableFactory.
+type identityRegistration struct {
+	identity Identity
+	factory  IdentifiableFactory
+}
+
+// IdentityRegistry maps the Identities to the Go types implementing them.
+// Code generated by Monolithe registers every Identity with a factory,
+// so generic code can find an Identity from a name or a category and
+// instantiate the corresponding Identifiable.
+type IdentityRegistry struct {
+	byName     map[string]*identityRegistration
+	byCategory map[string]*identityRegistration
+	schemas    map[string]*IdentitySchema
+	lock       sync.RWMutex
+}
+
+// NewIdentityRegistry returns a new empty *IdentityRegistry.
+func NewIdentityRegistry() *IdentityRegistry {
+
+	return &IdentityRegistry{
+		byName:     map[string]*identityRegistration{},
+		byCategory: map[string]*identityRegistration{},
+		schemas:    map[string]*IdentitySchema{},
+	}
+}
+
+// Register registers the given IdentifiableFactory for the given Identity.
+// If the Identity is already registered, the previous registration is replaced.
+// It panics if the Identity has no Name or Category, if the factory is nil or
+// if the Category is already registered for another Name.
+func (r *IdentityRegistry) Register(identity Identity, factory IdentifiableFactory) {
+
+	if identity.Name == "" || identity.Category == "" {
+		panic("bambou: cannot register an identity without name or category")
+	}
+
+	if factory == nil {
+		panic("bambou: cannot register a nil factory for " + identity.String())
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if other, exists := r.byCategory[identity.Category]; exists && other.identity.Name != identity.Name {
+		panic("bambou: cannot register " + identity.String() + ": its category is already registered for " + other.identity.String())
+	}
+
+	if previous, exists := r.byName[identity.Name]; exists {
+		delete(r.byCategory, previous.identity.Category)
+	}
+
+	registration := &identityRegistration{
+		identity: identity,
+		factory:  factory,
+	}
+
+	r.byName[identity.Name] = registration
+	r.byCategory[identity.Category] = registration
+}
+
+// Unregister removes the registration and the IdentitySchema of the given Identity.
+func (r *IdentityRegistry) Unregister(identity Identity) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.schemas, identity.Name)
+
+	if registration, exists := r.byName[identity.Name]; exists {
+		delete(r.byName, identity.Name)
+		delete(r.byCategory, registration.identity.Category)
+	}
+}
+
+// IdentityFromName returns the registered Identity with the given Name.
+func (r *IdentityRegistry) IdentityFromName(name string) (Identity, bool) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	registration, exists := r.byName[name]
+	if !exists {
+		return Identity{}, false
+	}
+
+	return registration.identity, true
+}
+
+// IdentityFromCategory returns the registered Identity with the given Category.
+func (r *IdentityRegistry) IdentityFromCategory(category string) (Identity, bool) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	registration, exists := r.byCategory[category]
+	if !exists {
+		return Identity{}, false
+	}
+
+	return registration.identity, true
+}
+
+// Identities returns all the registered Identities, sorted by Name.
+func (r *IdentityRegistry) Identities() []Identity {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	identities := make([]Identity, 0, len(r.byName))
+	for _, registration := range r.byName {
+		identities = append(identities, registration.identity)
+	}
+
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Name < identities[j].Name })
+
+	return identities
+}
+
+// RegisterSchema registers the given IdentitySchema for the given Identity.
+// The Identity does not need to be registered with a factory, so the
+// IdentitySchema of the root can be registered as well.
+// It panics if the Identity has no Name or if the IdentitySchema is nil.
+func (r *IdentityRegistry) RegisterSchema(identity Identity, schema *IdentitySchema) {
+
+	if identity.Name == "" {
+		panic("bambou: cannot register a schema for an identity without name")
+	}
+
+	if schema == nil {
+		panic("bambou: cannot register a nil schema for " + identity.String())
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.schemas[identity.Name] = schema
+}
+
+// Schema returns the IdentitySchema registered for the given Identity.
+func (r *IdentityRegistry) Schema(identity Identity) (*IdentitySchema, bool) {
+
+	return r.SchemaFromName(identity.Name)
+}
+
+// SchemaFromName returns the IdentitySchema registered for the Identity with the given Name.
+func (r *IdentityRegistry) SchemaFromName(name string) (*IdentitySchema, bool) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	schema, exists := r.schemas[name]
+
+	return schema, exists
+}
+
+// ChildrenIdentities returns the Identities of the owned children of the registered
+// IdentitySchemas, indexed by the Name of the Identity of their parent.
+func (r *IdentityRegistry) ChildrenIdentities() map[string][]Identity {
+
+	return r.relationships(false)
+}
+
+// MemberIdentities returns the Identities of the member children of the registered
+// IdentitySchemas, indexed by the Name of the Identity of their parent.
+func (r *IdentityRegistry) MemberIdentities() map[string][]Identity {
+
+	return r.relationships(true)
+}
+
+// relationships returns the Identities of the member or owned children of the registered IdentitySchemas.
+func (r *IdentityRegistry) relationships(member bool) map[string][]Identity {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	relationships := map[string][]Identity{}
+	for name, schema := range r.schemas {
+		if identities := schema.relationships(member); len(identities) > 0 {
+			relationships[name] = identities
+		}
+	}
+
+	return relationships
+}
+
+// New returns a new Identifiable for the given Identity, or nil if it is not registered.
+func (r *IdentityRegistry) New(identity Identity) Identifiable {
+
+	return r.NewFromName(identity.Name)
+}
+
+// NewFromName returns a new Identifiable for the Identity with the given Name,
+// or nil if it is not registered.
+func (r *IdentityRegistry) NewFromName(name string) Identifiable {
+
+	r.lock.RLock()
+	registration, exists := r.byName[name]
+	r.lock.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	return registration.factory()
+}
+
+// NewFromCategory returns a new Identifiable for the Identity with the given Category,
+// or nil if it is not registered.
+func (r *IdentityRegistry) NewFromCategory(category string) Identifiable {
+
+	r.lock.RLock()
+	registration, exists := r.byCategory[category]
+	r.lock.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	return registration.factory()
+}
+
+// DefaultIdentityRegistry is the IdentityRegistry used by the package level functions.
+var DefaultIdentityRegistry = NewIdentityRegistry()
+
+// RegisterIdentity registers the given IdentifiableFactory for the given Identity
+// in the DefaultIdentityRegistry.
+func RegisterIdentity(identity Identity, factory IdentifiableFactory) {
+
+	DefaultIdentityRegistry.Register(identity, factory)
+}
+
+// RegisterIdentitySchema registers the given IdentitySchema for the given Identity
+// in the DefaultIdentityRegistry.
+func RegisterIdentitySchema(identity Identity, schema *IdentitySchema) {
+
+	DefaultIdentityRegistry.RegisterSchema(identity, schema)
+}
+
+// IdentitySchemaOf returns the IdentitySchema of the given Identity from the DefaultIdentityRegistry.
+func IdentitySchemaOf(identity Identity) (*IdentitySchema, bool) {
+
+	return DefaultIdentityRegistry.Schema(identity)
+}
+
+// IdentityFromName returns the Identity with the given Name from the DefaultIdentityRegistry.
+func IdentityFromName(name string) (Identity, bool) {
+
+	return DefaultIdentityRegistry.IdentityFromName(name)
+}
+
+// IdentityFromCategory returns the Identity with the given Category from the DefaultIdentityRegistry.
+func IdentityFromCategory(category string) (Identity, bool) {
+
+	return DefaultIdentityRegistry.IdentityFromCategory(category)
+}
+
+// NewIdentifiable returns a new Identifiable for the given Identity using
+// the DefaultIdentityRegistry, or nil if it is not registered.
+func NewIdentifiable(identity Identity) Identifiable {
+
+	return DefaultIdentityRegistry.New(identity)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdentityRegistry_Register(t *testing.T) {
+
+	Convey("Given I create a new IdentityRegistry", t, func() {
+
+		r := NewIdentityRegistry()
+
+		Convey("When I register an identity", func() {
+
+			r.Register(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+
+			Convey("Then I should find it by name", func() {
+				i, ok := r.IdentityFromName("fake")
+				So(ok, ShouldBeTrue)
+				So(i, ShouldResemble, FakeIdentity)
+			})
+
+			Convey("Then I should find it by category", func() {
+				i, ok := r.IdentityFromCategory("fakes")
+				So(ok, ShouldBeTrue)
+				So(i, ShouldResemble, FakeIdentity)
+			})
+
+			Convey("Then I should be able to instantiate it", func() {
+				So(r.New(FakeIdentity), ShouldHaveSameTypeAs, &FakeObject{})
+				So(r.NewFromName("fake"), ShouldHaveSameTypeAs, &FakeObject{})
+				So(r.NewFromCategory("fakes"), ShouldHaveSameTypeAs, &FakeObject{})
+			})
+
+			Convey("Then each instance should be a new one", func() {
+				So(r.New(FakeIdentity), ShouldNotPointTo, r.New(FakeIdentity))
+			})
+
+			Convey("Then Identities should return it", func() {
+				So(r.Identities(), ShouldResemble, []Identity{FakeIdentity})
+			})
+
+			Convey("When I register the same identity name with another category", func() {
+
+				r.Register(Identity{"fake", "others"}, func() Identifiable { return NewFakeObject("") })
+
+				Convey("Then the previous category should not be registered anymore", func() {
+					_, ok := r.IdentityFromCategory("fakes")
+					So(ok, ShouldBeFalse)
+				})
+
+				Convey("Then the new category should be registered", func() {
+					_, ok := r.IdentityFromCategory("others")
+					So(ok, ShouldBeTrue)
+				})
+			})
+
+			Convey("When I register another identity name with the same category", func() {
+
+				Convey("Then it should panic", func() {
+					So(func() { r.Register(Identity{"other", "fakes"}, func() Identifiable { return NewFakeObject("") }) }, ShouldPanic)
+				})
+
+				Convey("Then the first identity should still be registered by category", func() {
+					func() {
+						defer func() { recover() }()
+						r.Register(Identity{"other", "fakes"}, func() Identifiable { return NewFakeObject("") })
+					}()
+					identity, ok := r.IdentityFromCategory("fakes")
+					So(ok, ShouldBeTrue)
+					So(identity, ShouldResemble, FakeIdentity)
+				})
+			})
+
+			Convey("When I unregister it", func() {
+
+				r.Unregister(FakeIdentity)
+
+				Convey("Then I should not find it anymore", func() {
+					_, ok1 := r.IdentityFromName("fake")
+					_, ok2 := r.IdentityFromCategory("fakes")
+					So(ok1, ShouldBeFalse)
+					So(ok2, ShouldBeFalse)
+				})
+
+				Convey("Then I should not be able to instantiate it", func() {
+					So(r.New(FakeIdentity), ShouldBeNil)
+					So(r.NewFromCategory("fakes"), ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When I register an identity without name", func() {
+
+			Convey("Then it should panic", func() {
+				So(func() { r.Register(Identity{"", "fakes"}, func() Identifiable { return nil }) }, ShouldPanic)
+			})
+		})
+
+		Convey("When I register an identity without factory", func() {
+
+			Convey("Then it should panic", func() {
+				So(func() { r.Register(FakeIdentity, nil) }, ShouldPanic)
+			})
+		})
+	})
+}
+
+func TestIdentityRegistry_DefaultIdentityRegistry(t *testing.T) {
+
+	Convey("Given I register an identity in the default registry", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		Convey("Then I should find it by name and category", func() {
+			i1, _ := IdentityFromName("fake")
+			i2, _ := IdentityFromCategory("fakes")
+			So(i1, ShouldResemble, FakeIdentity)
+			So(i2, ShouldResemble, FakeIdentity)
+		})
+
+		Convey("Then I should be able to instantiate it", func() {
+			So(NewIdentifiable(FakeIdentity), ShouldHaveSameTypeAs, &FakeObject{})
+		})
+	})
+}
+
+func TestIdentityRegistry_RegisterSchema(t *testing.T) {
+
+	Convey("Given I have an IdentityRegistry", t, func() {
+
+		r := NewIdentityRegistry()
+
+		Convey("When I register schemas", func() {
+
+			r.RegisterSchema(FakeRootIdentity, &IdentitySchema{Children: []Relationship{{Identity: FakeIdentity}}})
+			r.RegisterSchema(thingIdentity, thingSchema)
+
+			Convey("Then I should find them", func() {
+				s, ok := r.Schema(thingIdentity)
+				So(ok, ShouldBeTrue)
+				So(s, ShouldEqual, thingSchema)
+			})
+
+			Convey("Then I should get the owned children of all the identities", func() {
+				So(r.ChildrenIdentities(), ShouldResemble, map[string][]Identity{
+					"root":  {FakeIdentity},
+					"thing": {thingIdentity},
+				})
+			})
+
+			Convey("Then I should get the member children of all the identities", func() {
+				So(r.MemberIdentities(), ShouldResemble, map[string][]Identity{
+					"thing": {FakeIdentity},
+				})
+			})
+
+			Convey("When I unregister an identity", func() {
+
+				r.Unregister(thingIdentity)
+
+				Convey("Then its schema should be removed", func() {
+					_, ok := r.Schema(thingIdentity)
+					So(ok, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When I register an invalid schema", func() {
+
+			Convey("Then it should panic", func() {
+				So(func() { r.RegisterSchema(Identity{}, thingSchema) }, ShouldPanic)
+				So(func() { r.RegisterSchema(thingIdentity, nil) }, ShouldPanic)
+			})
+		})
+
+		Convey("Then an unknown schema should not be found", func() {
+			_, ok := r.SchemaFromName("unknown")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
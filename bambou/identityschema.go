@@ -0,0 +1,145 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Relationship represents the children with the given Identity that an Identity can have.
+// Member children are not owned by the parent, but assigned to it.
+type Relationship struct {
+	Identity Identity
+	Member   bool
+}
+
+// AttributeSchema represents an attribute of an Identity.
+// A read-only attribute is computed by the server and cannot be written.
+// A required attribute must be set to create an Identifiable.
+type AttributeSchema struct {
+	Name     string
+	ReadOnly bool
+	Required bool
+}
+
+// IdentitySchema describes the relationships and the attributes of an Identity.
+// Code generated by Monolithe registers the IdentitySchema of every Identity,
+// so generic code can walk, export or validate the Identifiables.
+type IdentitySchema struct {
+	Children   []Relationship
+	Attributes []AttributeSchema
+}
+
+// ChildrenIdentities returns the Identities of the children owned by the Identity.
+func (s *IdentitySchema) ChildrenIdentities() []Identity {
+
+	return s.relationships(false)
+}
+
+// MemberIdentities returns the Identities of the children that can be assigned to the Identity.
+func (s *IdentitySchema) MemberIdentities() []Identity {
+
+	return s.relationships(true)
+}
+
+// relationships returns the Identities of the member or owned children.
+func (s *IdentitySchema) relationships(member bool) []Identity {
+
+	var identities []Identity
+	for _, relationship := range s.Children {
+		if relationship.Member == member {
+			identities = append(identities, relationship.Identity)
+		}
+	}
+
+	return identities
+}
+
+// Attribute returns the AttributeSchema with the given name.
+func (s *IdentitySchema) Attribute(name string) (AttributeSchema, bool) {
+
+	for _, attribute := range s.Attributes {
+		if attribute.Name == name {
+			return attribute, true
+		}
+	}
+
+	return AttributeSchema{}, false
+}
+
+// ReadOnlyAttributes returns the names of the read-only attributes.
+func (s *IdentitySchema) ReadOnlyAttributes() []string {
+
+	var names []string
+	for _, attribute := range s.Attributes {
+		if attribute.ReadOnly {
+			names = append(names, attribute.Name)
+		}
+	}
+
+	return names
+}
+
+// Validate verifies that all the required attributes of the given Identifiable are set.
+func (s *IdentitySchema) Validate(object Identifiable) *Error {
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return NewBambouError("JSON error", err.Error())
+	}
+
+	attributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	var missing []string
+	for _, attribute := range s.Attributes {
+
+		if value, ok := attributes[attribute.Name]; attribute.Required && (!ok || value == nil || value == "") {
+			missing = append(missing, attribute.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return NewBambouError("Validation error", fmt.Sprintf("Missing required attributes of %s: %s", object.Identity().Name, strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+// readOnlyAttributesOf returns the default read-only attributes and the ones
+// of the IdentitySchema registered for the Identity with the given name.
+func readOnlyAttributesOf(name string) []string {
+
+	attributes := append([]string{}, defaultReadOnlyAttributes...)
+
+	if schema, ok := DefaultIdentityRegistry.SchemaFromName(name); ok {
+		attributes = append(attributes, schema.ReadOnlyAttributes()...)
+	}
+
+	return attributes
+}
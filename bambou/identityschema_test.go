@@ -0,0 +1,115 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// thingSchema is the IdentitySchema of a thing.
+var thingSchema = &IdentitySchema{
+	Children: []Relationship{
+		{Identity: thingIdentity},
+		{Identity: FakeIdentity, Member: true},
+	},
+	Attributes: []AttributeSchema{
+		{Name: "name", Required: true},
+		{Name: "description"},
+		{Name: "status", ReadOnly: true},
+	},
+}
+
+func TestIdentitySchema_Relationships(t *testing.T) {
+
+	Convey("Given I have an IdentitySchema", t, func() {
+
+		Convey("Then I should get the owned children", func() {
+			So(thingSchema.ChildrenIdentities(), ShouldResemble, []Identity{thingIdentity})
+		})
+
+		Convey("Then I should get the member children", func() {
+			So(thingSchema.MemberIdentities(), ShouldResemble, []Identity{FakeIdentity})
+		})
+	})
+}
+
+func TestIdentitySchema_Attributes(t *testing.T) {
+
+	Convey("Given I have an IdentitySchema", t, func() {
+
+		Convey("Then I should find an attribute by name", func() {
+			a, ok := thingSchema.Attribute("status")
+			So(ok, ShouldBeTrue)
+			So(a.ReadOnly, ShouldBeTrue)
+		})
+
+		Convey("Then I should not find an unknown attribute", func() {
+			_, ok := thingSchema.Attribute("unknown")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then I should get the read-only attributes", func() {
+			So(thingSchema.ReadOnlyAttributes(), ShouldResemble, []string{"status"})
+		})
+
+		Convey("When I register it", func() {
+
+			RegisterIdentitySchema(thingIdentity, thingSchema)
+			defer DefaultIdentityRegistry.Unregister(thingIdentity)
+
+			Convey("Then its read-only attributes should be added to the default ones", func() {
+				So(readOnlyAttributesOf("thing"), ShouldResemble, append(append([]string{}, defaultReadOnlyAttributes...), "status"))
+				So(readOnlyAttributesOf("fake"), ShouldResemble, defaultReadOnlyAttributes)
+			})
+		})
+	})
+}
+
+func TestIdentitySchema_Validate(t *testing.T) {
+
+	Convey("Given I have an IdentitySchema with a required attribute", t, func() {
+
+		Convey("When I validate an object with the attribute", func() {
+
+			err := thingSchema.Validate(newThing("a", ""))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I validate an object without the attribute", func() {
+
+			err := thingSchema.Validate(newThing("", "d"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Validation error")
+				So(err.Description, ShouldContainSubstring, "name")
+			})
+		})
+	})
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Indexer is the prototype of a function returning the values under which
+// an Identifiable is indexed by an Informer.
+type Indexer func(Identifiable) []string
+
+// AttributeIndexer returns an Indexer indexing the Identifiables by the value
+// of the given JSON attribute, like "name", "parentID" or "externalID".
+// Identifiables without a string value for that attribute are not indexed.
+func AttributeIndexer(attribute string) Indexer {
+
+	return func(object Identifiable) []string {
+
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil
+		}
+
+		attributes := map[string]interface{}{}
+		if err := json.Unmarshal(data, &attributes); err != nil {
+			return nil
+		}
+
+		value, ok := attributes[attribute].(string)
+		if !ok || value == "" {
+			return nil
+		}
+
+		return []string{value}
+	}
+}
+
+// Informer keeps a local cache of the children with a given Identity of a
+// parent. Once running, it lists all the children, then keeps the cache up
+// to date with the events received by a PushCenter and optionally lists
+// the children again every ResyncPeriod. The listings run in the background:
+// the changes received meanwhile are kept and applied after the listing.
+//
+// The callbacks are called from the goroutine running the Informer, after
+// the cache reflects the change. The Identity must be registered in the
+// DefaultIdentityRegistry.
+type Informer struct {
+
+	// ResyncPeriod is the delay between two listings of the children. Zero disables the resync.
+	ResyncPeriod time.Duration
+
+	// OnAdd is called when an Identifiable is added to the cache.
+	OnAdd func(Identifiable)
+
+	// OnUpdate is called when an Identifiable of the cache is updated.
+	OnUpdate func(old Identifiable, new Identifiable)
+
+	// OnDelete is called when an Identifiable is removed from the cache.
+	OnDelete func(Identifiable)
+
+	// OnError is called when a periodic resync fails.
+	OnError func(*Error)
+
+	storer     Storer
+	pushCenter *PushCenter
+	parent     Identifiable
+	identity   Identity
+	indexers   map[string]Indexer
+	objects    map[string]Identifiable
+	indices    map[string]map[string]map[string]Identifiable
+	synced     bool
+	loop       *informerLoop
+	lock       sync.RWMutex
+}
+
+// NewInformer returns a new *Informer caching the children with the given Identity of the given parent,
+// fetched from the given Storer and updated from the events of the given PushCenter.
+func NewInformer(storer Storer, pushCenter *PushCenter, parent Identifiable, identity Identity) *Informer {
+
+	return &Informer{
+		storer:     storer,
+		pushCenter: pushCenter,
+		parent:     parent,
+		identity:   identity,
+		indexers:   map[string]Indexer{},
+		objects:    map[string]Identifiable{},
+		indices:    map[string]map[string]map[string]Identifiable{},
+	}
+}
+
+// AddIndexer adds an Indexer with the given name. It must be called before Run.
+func (i *Informer) AddIndexer(name string, indexer Indexer) {
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.indexers[name] = indexer
+	i.indices[name] = map[string]map[string]Identifiable{}
+
+	for _, object := range i.objects {
+		i.index(name, object)
+	}
+}
+
+// informerListing represents the result of a listing of the children.
+type informerListing struct {
+	children IdentifiablesList
+	err      *Error
+}
+
+// informerLoop represents a running Informer, receiving the resync requests.
+type informerLoop struct {
+	resyncs chan chan *Error
+	stopped chan struct{}
+}
+
+// Run lists the children and keeps the cache up to date until the given context is done.
+// It returns an error if the first listing fails.
+//
+// The changes received while the children are listed are applied after the listing,
+// unless the listed Identifiable has a more recent lastUpdatedDate. The changes of
+// the Identifiables without lastUpdatedDate are always applied.
+func (i *Informer) Run(ctx context.Context) *Error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	loop := &informerLoop{
+		resyncs: make(chan chan *Error),
+		stopped: make(chan struct{}),
+	}
+
+	i.lock.Lock()
+	i.loop = loop
+	i.lock.Unlock()
+
+	var requested, waiting []chan *Error
+
+	defer func() {
+		i.lock.Lock()
+		i.loop = nil
+		i.lock.Unlock()
+
+		close(loop.stopped)
+
+		for _, waiter := range append(requested, waiting...) {
+			waiter <- NewBambouError("Informer error", "The informer has been stopped before the resync")
+		}
+	}()
+
+	changes := i.pushCenter.Watch(ctx, i.parent, i.identity)
+
+	listings := make(chan *informerListing, 1)
+	listing := false
+	var pending []*Change
+
+	list := func() {
+		listing = true
+		waiting, requested = requested, nil
+		go func() {
+			children, berr := FetchAllChildren(i.storer, i.parent, i.identity)
+			listings <- &informerListing{children: children, err: berr}
+		}()
+	}
+
+	list()
+
+	var resync <-chan time.Time
+	if i.ResyncPeriod > 0 {
+		ticker := time.NewTicker(i.ResyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	for {
+		select {
+
+		case change := <-changes:
+			if change == nil {
+				return nil
+			}
+			if listing {
+				pending = append(pending, change)
+			} else {
+				i.apply(change)
+			}
+
+		case result := <-listings:
+			listing = false
+
+			if result.err != nil {
+				if !i.HasSynced() {
+					return result.err
+				}
+				if i.OnError != nil && len(waiting) == 0 {
+					i.OnError(result.err)
+				}
+				for _, change := range pending {
+					i.apply(change)
+				}
+			} else {
+				i.sync(result.children)
+				i.applyPending(result.children, pending)
+			}
+			pending = nil
+
+			for _, waiter := range waiting {
+				waiter <- result.err
+			}
+			waiting = nil
+
+			if len(requested) > 0 {
+				list()
+			}
+
+		case waiter := <-loop.resyncs:
+			requested = append(requested, waiter)
+			if !listing {
+				list()
+			}
+
+		case <-resync:
+			if !listing {
+				list()
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Resync lists all the children and updates the cache accordingly.
+// If the Informer is running, the listing is done by Run, so the callbacks
+// are still called from its goroutine, and Resync waits for it.
+func (i *Informer) Resync() *Error {
+
+	i.lock.RLock()
+	loop := i.loop
+	i.lock.RUnlock()
+
+	if loop == nil {
+
+		children, berr := FetchAllChildren(i.storer, i.parent, i.identity)
+		if berr != nil {
+			return berr
+		}
+
+		i.sync(children)
+
+		return nil
+	}
+
+	waiter := make(chan *Error, 1)
+
+	select {
+	case loop.resyncs <- waiter:
+		return <-waiter
+	case <-loop.stopped:
+		return NewBambouError("Informer error", "The informer has been stopped before the resync")
+	}
+}
+
+// applyPending applies the given changes received while the given children were listed,
+// except the ones older than the listed Identifiables.
+func (i *Informer) applyPending(children IdentifiablesList, pending []*Change) {
+
+	listed := make(map[string]Identifiable, len(children))
+	for _, child := range children {
+		listed[child.Identifier()] = child
+	}
+
+	for _, change := range pending {
+
+		if current, ok := listed[change.Entity.Identifier()]; ok && change.Type != EventTypeDelete && isOlder(change.Entity, current) {
+			continue
+		}
+
+		i.apply(change)
+	}
+}
+
+// sync updates the cache with the given listed children.
+func (i *Informer) sync(children IdentifiablesList) {
+
+	listed := map[string]bool{}
+	for _, child := range children {
+		listed[child.Identifier()] = true
+		i.set(child)
+	}
+
+	i.lock.RLock()
+	var deleted []string
+	for ID := range i.objects {
+		if !listed[ID] {
+			deleted = append(deleted, ID)
+		}
+	}
+	i.lock.RUnlock()
+
+	for _, ID := range deleted {
+		i.remove(ID)
+	}
+
+	i.lock.Lock()
+	i.synced = true
+	i.lock.Unlock()
+}
+
+// isOlder returns true if the lastUpdatedDate of the given object is before the one of the given
+// current object. It returns false if one of them has no lastUpdatedDate.
+func isOlder(object Identifiable, current Identifiable) bool {
+
+	date, ok1 := lastUpdatedDate(object)
+	currentDate, ok2 := lastUpdatedDate(current)
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	number, err1 := strconv.ParseFloat(date, 64)
+	currentNumber, err2 := strconv.ParseFloat(currentDate, 64)
+	if err1 == nil && err2 == nil {
+		return number < currentNumber
+	}
+
+	return date < currentDate
+}
+
+// HasSynced returns true once the children have been listed.
+func (i *Informer) HasSynced() bool {
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return i.synced
+}
+
+// Get returns the cached Identifiable with the given identifier.
+func (i *Informer) Get(ID string) (Identifiable, bool) {
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	object, ok := i.objects[ID]
+
+	return object, ok
+}
+
+// List returns all the cached Identifiables.
+func (i *Informer) List() IdentifiablesList {
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	objects := make(IdentifiablesList, 0, len(i.objects))
+	for _, object := range i.objects {
+		objects = append(objects, object)
+	}
+
+	return objects
+}
+
+// ByIndex returns the cached Identifiables indexed under the given value by the Indexer with the given name.
+func (i *Informer) ByIndex(name string, value string) IdentifiablesList {
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	objects := IdentifiablesList{}
+	for _, object := range i.indices[name][value] {
+		objects = append(objects, object)
+	}
+
+	return objects
+}
+
+// apply updates the cache with the given Change.
+func (i *Informer) apply(change *Change) {
+
+	if change.Type == EventTypeDelete {
+		i.remove(change.Entity.Identifier())
+		return
+	}
+
+	i.set(change.Entity)
+}
+
+// set adds or updates the given Identifiable in the cache and calls the callbacks.
+func (i *Informer) set(object Identifiable) {
+
+	i.lock.Lock()
+
+	old, exists := i.objects[object.Identifier()]
+	if exists && reflect.DeepEqual(old, object) {
+		i.lock.Unlock()
+		return
+	}
+
+	if exists {
+		i.unindex(old)
+	}
+
+	i.objects[object.Identifier()] = object
+	for name := range i.indexers {
+		i.index(name, object)
+	}
+
+	i.lock.Unlock()
+
+	if !exists && i.OnAdd != nil {
+		i.OnAdd(object)
+	}
+
+	if exists && i.OnUpdate != nil {
+		i.OnUpdate(old, object)
+	}
+}
+
+// remove removes the Identifiable with the given identifier from the cache and calls the callbacks.
+func (i *Informer) remove(ID string) {
+
+	i.lock.Lock()
+
+	old, exists := i.objects[ID]
+	if !exists {
+		i.lock.Unlock()
+		return
+	}
+
+	i.unindex(old)
+	delete(i.objects, ID)
+
+	i.lock.Unlock()
+
+	if i.OnDelete != nil {
+		i.OnDelete(old)
+	}
+}
+
+// index indexes the given Identifiable with the Indexer with the given name.
+func (i *Informer) index(name string, object Identifiable) {
+
+	for _, value := range i.indexers[name](object) {
+
+		if i.indices[name][value] == nil {
+			i.indices[name][value] = map[string]Identifiable{}
+		}
+
+		i.indices[name][value][object.Identifier()] = object
+	}
+}
+
+// unindex removes the given Identifiable from all the indices.
+func (i *Informer) unindex(object Identifiable) {
+
+	for name, indexer := range i.indexers {
+		for _, value := range indexer(object) {
+
+			delete(i.indices[name][value], object.Identifier())
+
+			if len(i.indices[name][value]) == 0 {
+				delete(i.indices[name], value)
+			}
+		}
+	}
+}
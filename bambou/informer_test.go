@@ -0,0 +1,428 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInformer_AttributeIndexer(t *testing.T) {
+
+	Convey("Given I have an AttributeIndexer on the name", t, func() {
+
+		indexer := AttributeIndexer("name")
+
+		Convey("Then an object with a name should be indexed by its name", func() {
+			o := NewFakeObject("x")
+			o.Name = "hello"
+			So(indexer(o), ShouldResemble, []string{"hello"})
+		})
+
+		Convey("Then an object without name should not be indexed", func() {
+			So(indexer(NewFakeObject("x")), ShouldBeNil)
+		})
+	})
+}
+
+func TestInformer_Resync(t *testing.T) {
+
+	Convey("Given I have an Informer on the children of a MemoryStorer", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		o1 := NewFakeObject("1")
+		o1.Name = "a"
+		o2 := NewFakeObject("2")
+		o2.Name = "b"
+		m.CreateChild(r, o1)
+		m.CreateChild(r, o2)
+
+		var added, deleted []string
+		var updated []string
+
+		i := NewInformer(m, nil, r, FakeIdentity)
+		i.AddIndexer("name", AttributeIndexer("name"))
+		i.OnAdd = func(o Identifiable) { added = append(added, o.Identifier()) }
+		i.OnUpdate = func(old Identifiable, new Identifiable) {
+			updated = append(updated, old.(*FakeObject).Name+">"+new.(*FakeObject).Name)
+		}
+		i.OnDelete = func(o Identifiable) { deleted = append(deleted, o.Identifier()) }
+
+		Convey("When I resync it", func() {
+
+			err := i.Resync()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then it should be synced", func() {
+				So(i.HasSynced(), ShouldBeTrue)
+			})
+
+			Convey("Then the cache should contain the children", func() {
+				So(len(i.List()), ShouldEqual, 2)
+				o, ok := i.Get("1")
+				So(ok, ShouldBeTrue)
+				So(o.(*FakeObject).Name, ShouldEqual, "a")
+			})
+
+			Convey("Then the children should be indexed", func() {
+				So(len(i.ByIndex("name", "b")), ShouldEqual, 1)
+				So(len(i.ByIndex("name", "c")), ShouldEqual, 0)
+			})
+
+			Convey("Then OnAdd should have been called for each child", func() {
+				So(added, ShouldResemble, []string{"1", "2"})
+			})
+
+			Convey("When I change the children and resync it again", func() {
+
+				o1.Name = "c"
+				m.SaveEntity(o1)
+				m.DeleteEntity(o2)
+				m.CreateChild(r, NewFakeObject("3"))
+
+				err := i.Resync()
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the callbacks should have been called", func() {
+					So(added, ShouldResemble, []string{"1", "2", "3"})
+					So(updated, ShouldResemble, []string{"a>c"})
+					So(deleted, ShouldResemble, []string{"2"})
+				})
+
+				Convey("Then the indices should be updated", func() {
+					So(len(i.ByIndex("name", "a")), ShouldEqual, 0)
+					So(len(i.ByIndex("name", "b")), ShouldEqual, 0)
+					So(i.ByIndex("name", "c")[0].Identifier(), ShouldEqual, "1")
+				})
+			})
+		})
+	})
+}
+
+func TestInformer_Run(t *testing.T) {
+
+	Convey("Given I have a running Informer on the children of a MemoryStorer", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		m.CreateChild(r, NewFakeObject("1"))
+
+		p := NewPushCenter(m)
+		startPushCenter(p)
+		defer p.Stop()
+
+		events := make(chan string, 10)
+
+		i := NewInformer(m, p, r, FakeIdentity)
+		i.AddIndexer("name", AttributeIndexer("name"))
+		i.OnAdd = func(o Identifiable) {
+			if _, cached := i.Get(o.Identifier()); cached {
+				events <- "add " + o.Identifier()
+			}
+		}
+		i.OnUpdate = func(old Identifiable, new Identifiable) { events <- "update " + new.Identifier() }
+		i.OnDelete = func(o Identifiable) {
+			if _, cached := i.Get(o.Identifier()); !cached {
+				events <- "delete " + o.Identifier()
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan *Error, 1)
+		go func() { done <- i.Run(ctx) }()
+
+		next := func() string {
+			select {
+			case e := <-events:
+				return e
+			case <-time.After(time.Second):
+				return ""
+			}
+		}
+
+		So(next(), ShouldEqual, "add 1")
+
+		Convey("When I create, update and delete a child", func() {
+
+			o := NewFakeObject("2")
+			m.CreateChild(r, o)
+			add := next()
+
+			o.Name = "hello"
+			m.SaveEntity(o)
+			update := next()
+			indexed := len(i.ByIndex("name", "hello"))
+
+			m.DeleteEntity(o)
+			deletion := next()
+
+			cancel()
+			err := <-done
+
+			Convey("Then the callbacks should have been called", func() {
+				So(add, ShouldEqual, "add 2")
+				So(update, ShouldEqual, "update 2")
+				So(deletion, ShouldEqual, "delete 2")
+			})
+
+			Convey("Then the updated child should have been indexed", func() {
+				So(indexed, ShouldEqual, 1)
+			})
+
+			Convey("Then Run should return without error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// blockingStorer is a MemoryStorer returning the fetched children once released.
+type blockingStorer struct {
+	*MemoryStorer
+	release chan struct{}
+}
+
+func (s *blockingStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	berr := s.MemoryStorer.FetchChildren(parent, identity, dest, info)
+	<-s.release
+
+	return berr
+}
+
+func TestInformer_RunWhileListing(t *testing.T) {
+
+	Convey("Given I have a running Informer with a slow listing", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		m.CreateChild(r, NewFakeObject("1"))
+
+		p := NewPushCenter(m)
+		startPushCenter(p)
+		defer p.Stop()
+
+		received := make(chan struct{}, 1)
+		p.RegisterHandlerForIdentity(func(*Event) { received <- struct{}{} }, FakeIdentity)
+
+		s := &blockingStorer{MemoryStorer: m, release: make(chan struct{})}
+		i := NewInformer(s, p, r, FakeIdentity)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go i.Run(ctx)
+
+		Convey("When a child is created during the listing", func() {
+
+			m.CreateChild(r, NewFakeObject("2"))
+
+			select {
+			case <-received:
+			case <-time.After(time.Second):
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			synced := i.HasSynced()
+			close(s.release)
+
+			for start := time.Now(); len(i.List()) < 2 && time.Since(start) < time.Second; {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			Convey("Then the Informer should not be synced before the end of the listing", func() {
+				So(synced, ShouldBeFalse)
+			})
+
+			Convey("Then the change should be applied after the listing", func() {
+				_, ok1 := i.Get("1")
+				_, ok2 := i.Get("2")
+				So(ok1, ShouldBeTrue)
+				So(ok2, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// delayedStorer is a MemoryStorer listing the children once released.
+type delayedStorer struct {
+	*MemoryStorer
+	release chan struct{}
+}
+
+func (s *delayedStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	<-s.release
+
+	return s.MemoryStorer.FetchChildren(parent, identity, dest, info)
+}
+
+func TestInformer_RunWithStaleChanges(t *testing.T) {
+
+	Convey("Given I have a running Informer listing the children after receiving updates", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return &datedFakeObject{} })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		o := &datedFakeObject{FakeObject: FakeObject{ID: "1", Name: "a"}, LastUpdatedDate: "1"}
+		m.CreateChild(r, o)
+
+		p := NewPushCenter(m)
+		startPushCenter(p)
+		defer p.Stop()
+
+		received := make(chan struct{}, 2)
+		p.RegisterHandlerForIdentity(func(*Event) { received <- struct{}{} }, FakeIdentity)
+
+		s := &delayedStorer{MemoryStorer: m, release: make(chan struct{})}
+		i := NewInformer(s, p, r, FakeIdentity)
+
+		var lock sync.Mutex
+		var names []string
+		i.OnAdd = func(o Identifiable) {
+			lock.Lock()
+			names = append(names, o.(*datedFakeObject).Name)
+			lock.Unlock()
+		}
+		i.OnUpdate = func(old Identifiable, new Identifiable) {
+			lock.Lock()
+			names = append(names, new.(*datedFakeObject).Name)
+			lock.Unlock()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go i.Run(ctx)
+
+		Convey("When the object is updated twice before the listing", func() {
+
+			o.Name, o.LastUpdatedDate = "old", "2"
+			m.SaveEntity(o)
+			o.Name, o.LastUpdatedDate = "new", "3"
+			m.SaveEntity(o)
+
+			for n := 0; n < 2; n++ {
+				select {
+				case <-received:
+				case <-time.After(time.Second):
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(s.release)
+
+			for start := time.Now(); !i.HasSynced() && time.Since(start) < time.Second; {
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			Convey("Then the older change should not overwrite the listed object", func() {
+				lock.Lock()
+				defer lock.Unlock()
+				So(names, ShouldResemble, []string{"new"})
+			})
+		})
+	})
+}
+
+func TestInformer_ResyncWhileRunning(t *testing.T) {
+
+	Convey("Given I have a running Informer without push center events", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		m.CreateChild(r, NewFakeObject("1"))
+
+		i := NewInformer(m, NewPushCenter(m), r, FakeIdentity)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+
+		go func() {
+			i.Run(ctx)
+			close(stopped)
+		}()
+
+		for start := time.Now(); !i.HasSynced() && time.Since(start) < time.Second; {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		Convey("When I create a child and resync it", func() {
+
+			m.CreateChild(r, NewFakeObject("2"))
+			err := i.Resync()
+
+			cancel()
+			<-stopped
+
+			Convey("Then the listing should be done by Run", func() {
+				So(err, ShouldBeNil)
+				_, ok := i.Get("2")
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I resync it once stopped", func() {
+
+			cancel()
+			<-stopped
+
+			m.CreateChild(r, NewFakeObject("2"))
+			err := i.Resync()
+
+			Convey("Then the children should be listed directly", func() {
+				So(err, ShouldBeNil)
+				_, ok := i.Get("2")
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,568 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryDefaultPageSize is the page size used when none is given, like the
+// one sent by the Session.
+const memoryDefaultPageSize = 50
+
+// memoryDefaultEventHistory is the number of notifications kept when no EventHistory is given.
+const memoryDefaultEventHistory = 1000
+
+// memoryRecord represents an object stored in a MemoryStorer.
+type memoryRecord struct {
+	identity Identity
+	parent   string
+	data     []byte
+}
+
+// MemoryStorer is a Storer that keeps all the objects in memory.
+// It is meant to be used for unit tests and offline tooling: objects
+// are kept as JSON, so they go through the same encoding the Session
+// would use, and every mutation generates a Notification that can be
+// retrieved using NextEvent. Only the last notifications are kept: like
+// the server, NextEvent returns an error for a notification that is not
+// known anymore.
+//
+// The Filter, OrderBy and GroupBy values of a FetchingInfo are ignored.
+type MemoryStorer struct {
+
+	// EventTimeout is the maximum time NextEvent waits for a new
+	// notification. If zero, NextEvent waits until one is available.
+	EventTimeout time.Duration
+
+	// EventHistory is the number of notifications kept for NextEvent.
+	// If zero, the last 1000 notifications are kept.
+	EventHistory int
+
+	root          Rootable
+	objects       map[string]*memoryRecord
+	children      map[string][]string
+	assignments   map[string][]string
+	notifications []*Notification
+	origin        string
+	notify        chan struct{}
+	lock          sync.RWMutex
+}
+
+// NewMemoryStorer returns a new empty *MemoryStorer using the given Rootable as root object.
+func NewMemoryStorer(root Rootable) *MemoryStorer {
+
+	return &MemoryStorer{
+		root:        root,
+		objects:     map[string]*memoryRecord{},
+		children:    map[string][]string{},
+		assignments: map[string][]string{},
+		origin:      newMemoryIdentifier(),
+		notify:      make(chan struct{}),
+	}
+}
+
+// Root returns the Root API object.
+func (m *MemoryStorer) Root() Rootable {
+
+	return m.root
+}
+
+// Start starts the MemoryStorer and sets it as the current session.
+func (m *MemoryStorer) Start() *Error {
+
+	currentSession = m
+
+	return nil
+}
+
+// Reset resets the MemoryStorer. Stored objects are kept.
+func (m *MemoryStorer) Reset() {
+
+	m.root.SetAPIKey("")
+
+	currentSession = nil
+}
+
+// FetchEntity fetchs the given Identifiable from the memory.
+func (m *MemoryStorer) FetchEntity(object Identifiable) *Error {
+
+	if _, ok := object.(Rootable); ok {
+		return nil
+	}
+
+	m.lock.RLock()
+	record, berr := m.record(object.Identity(), object.Identifier())
+	m.lock.RUnlock()
+
+	if berr != nil {
+		return berr
+	}
+
+	if err := json.Unmarshal(record.data, object); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return nil
+}
+
+// SaveEntity saves the given Identifiable into the memory.
+func (m *MemoryStorer) SaveEntity(object Identifiable) *Error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	record, berr := m.record(object.Identity(), object.Identifier())
+	if berr != nil {
+		return berr
+	}
+
+	data, berr := m.encode(object, m.parentOf(record))
+	if berr != nil {
+		return berr
+	}
+
+	record.data = data
+	m.publish(EventTypeUpdate, record)
+
+	if err := json.Unmarshal(data, object); err != nil {
+		return NewBambouError("JSON Unmarshaling error", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteEntity deletes the given Identifiable and all its children from the memory.
+func (m *MemoryStorer) DeleteEntity(object Identifiable) *Error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, berr := m.record(object.Identity(), object.Identifier()); berr != nil {
+		return berr
+	}
+
+	m.delete(memoryKey(object.Identity(), object.Identifier()))
+
+	return nil
+}
+
+// FetchChildren fetches the children of the given parent identified by the given Identity.
+// Both the children created under the parent and the ones assigned to it are returned.
+func (m *MemoryStorer) FetchChildren(parent Identifiable, identity Identity, dest interface{}, info *FetchingInfo) *Error {
+
+	m.lock.RLock()
+
+	parentKey, berr := m.keyOf(parent)
+	if berr != nil {
+		m.lock.RUnlock()
+		return berr
+	}
+
+	relation := memoryRelation(parentKey, identity)
+	keys := append(append([]string{}, m.children[relation]...), m.assignments[relation]...)
+
+	page, pageSize := 0, memoryDefaultPageSize
+	if info != nil {
+		if info.Page > 0 {
+			page = info.Page
+		}
+		if info.PageSize > 0 {
+			pageSize = info.PageSize
+		}
+		info.Page = page
+		info.PageSize = pageSize
+		info.TotalCount = len(keys)
+	}
+
+	var items []string
+	for i := page * pageSize; i < len(keys) && i < (page+1)*pageSize; i++ {
+		items = append(items, string(m.objects[keys[i]].data))
+	}
+
+	m.lock.RUnlock()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte("["+strings.Join(items, ",")+"]"), &dest); err != nil {
+		return NewBambouError("HTTP Unmarshaling error", err.Error())
+	}
+
+	return nil
+}
+
+// CreateChild creates a new child Identifiable under the given parent Identifiable in the memory.
+// If the child has no identifier, a new one is generated.
+func (m *MemoryStorer) CreateChild(parent Identifiable, child Identifiable) *Error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	parentKey, berr := m.keyOf(parent)
+	if berr != nil {
+		return berr
+	}
+
+	if child.Identifier() == "" {
+		child.SetIdentifier(newMemoryIdentifier())
+	}
+
+	key := memoryKey(child.Identity(), child.Identifier())
+	if _, exists := m.objects[key]; exists {
+		berr := NewBambouError("Object already exists", fmt.Sprintf("An object with ID %s already exists", child.Identifier()))
+		berr.Code = http.StatusConflict
+		return berr
+	}
+
+	data, berr := m.encode(child, parent)
+	if berr != nil {
+		return berr
+	}
+
+	record := &memoryRecord{
+		identity: child.Identity(),
+		parent:   parentKey,
+		data:     data,
+	}
+
+	relation := memoryRelation(parentKey, child.Identity())
+	m.objects[key] = record
+	m.children[relation] = append(m.children[relation], key)
+	m.publish(EventTypeCreate, record)
+
+	if err := json.Unmarshal(data, child); err != nil {
+		return NewBambouError("JSON Unmarshaling error", err.Error())
+	}
+
+	return nil
+}
+
+// AssignChildren assigns the list of given child Identifiables to the given Identifiable parent in the memory.
+// The previous assignments for the given Identity are replaced.
+func (m *MemoryStorer) AssignChildren(parent Identifiable, children []Identifiable, identity Identity) *Error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	parentKey, berr := m.keyOf(parent)
+	if berr != nil {
+		return berr
+	}
+
+	keys := make([]string, len(children))
+	for i, c := range children {
+
+		if c.Identifier() == "" {
+			return NewBambouError("VSD Error", "One of the object to assign has no ID")
+		}
+
+		if _, berr := m.record(identity, c.Identifier()); berr != nil {
+			return berr
+		}
+
+		keys[i] = memoryKey(identity, c.Identifier())
+	}
+
+	m.assignments[memoryRelation(parentKey, identity)] = keys
+
+	if record, exists := m.objects[parentKey]; exists {
+		m.publish(EventTypeUpdate, record)
+	}
+
+	return nil
+}
+
+// NextEvent sends the notification following the given lastEventID to the given channel.
+// If lastEventID is empty, like the server, a notification without events carrying the
+// identifier of the last notification is sent, so the following calls only return new events.
+// If there is no new notification yet, it waits for one or for the EventTimeout.
+func (m *MemoryStorer) NextEvent(channel NotificationsChannel, lastEventID string) *Error {
+
+	return m.NextEventContext(context.Background(), channel, lastEventID)
+}
+
+// NextEventContext is like NextEvent, but it stops waiting when the given context is done.
+func (m *MemoryStorer) NextEventContext(ctx context.Context, channel NotificationsChannel, lastEventID string) *Error {
+
+	var timeout <-chan time.Time
+	if m.EventTimeout > 0 {
+		timeout = time.After(m.EventTimeout)
+	}
+
+	for {
+		m.lock.RLock()
+		notification, berr := m.notificationAfter(lastEventID)
+		wait := m.notify
+		m.lock.RUnlock()
+
+		if berr != nil {
+			return berr
+		}
+
+		if notification != nil {
+			channel <- notification
+			return nil
+		}
+
+		select {
+		case <-wait:
+		case <-timeout:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// record returns the memoryRecord for the given identity and identifier.
+// It must be called with the lock held.
+func (m *MemoryStorer) record(identity Identity, identifier string) (*memoryRecord, *Error) {
+
+	if identifier == "" {
+		return nil, NewBambouError("Missing identifier", fmt.Sprintf("Cannot find %s with no ID set", identity.Name))
+	}
+
+	record, exists := m.objects[memoryKey(identity, identifier)]
+	if !exists {
+		berr := NewBambouError("Object not found", fmt.Sprintf("Cannot find %s with ID %s", identity.Name, identifier))
+		berr.Code = http.StatusNotFound
+		return nil, berr
+	}
+
+	return record, nil
+}
+
+// keyOf returns the key of the given parent, verifying it exists.
+// It must be called with the lock held.
+func (m *MemoryStorer) keyOf(parent Identifiable) (string, *Error) {
+
+	if _, ok := parent.(Rootable); ok {
+		return memoryKey(parent.Identity(), ""), nil
+	}
+
+	if _, berr := m.record(parent.Identity(), parent.Identifier()); berr != nil {
+		return "", berr
+	}
+
+	return memoryKey(parent.Identity(), parent.Identifier()), nil
+}
+
+// parentOf returns a lightweight Identifiable representing the parent of the given record,
+// or nil if the record has been created under the root object.
+// It must be called with the lock held.
+func (m *MemoryStorer) parentOf(record *memoryRecord) Identifiable {
+
+	parent, exists := m.objects[record.parent]
+	if !exists {
+		return nil
+	}
+
+	return &identifiableReference{
+		identity:   parent.identity,
+		identifier: strings.TrimPrefix(record.parent, parent.identity.Name+"/"),
+	}
+}
+
+// encode returns the JSON representation of the given object, carrying the parentID
+// and parentType of the given parent like the server would.
+func (m *MemoryStorer) encode(object Identifiable, parent Identifiable) ([]byte, *Error) {
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	attributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	attributes["ID"] = object.Identifier()
+
+	if _, ok := parent.(Rootable); parent != nil && !ok {
+		attributes["parentID"] = parent.Identifier()
+		attributes["parentType"] = parent.Identity().Name
+	}
+
+	if data, err = json.Marshal(attributes); err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	return data, nil
+}
+
+// delete removes the object with the given key, its children and all assignments
+// pointing to it. It must be called with the lock held.
+func (m *MemoryStorer) delete(key string) {
+
+	record, exists := m.objects[key]
+	if !exists {
+		return
+	}
+
+	prefix := key + "|"
+	for relation, keys := range m.children {
+		if strings.HasPrefix(relation, prefix) {
+			for _, k := range append([]string{}, keys...) {
+				m.delete(k)
+			}
+			delete(m.children, relation)
+		}
+	}
+
+	for relation := range m.assignments {
+		if strings.HasPrefix(relation, prefix) {
+			delete(m.assignments, relation)
+			continue
+		}
+		m.assignments[relation] = removeString(m.assignments[relation], key)
+	}
+
+	relation := memoryRelation(record.parent, record.identity)
+	m.children[relation] = removeString(m.children[relation], key)
+
+	delete(m.objects, key)
+	m.publish(EventTypeDelete, record)
+}
+
+// publish appends a new Notification for the given record.
+// It must be called with the lock held.
+func (m *MemoryStorer) publish(eventType EventType, record *memoryRecord) {
+
+	entity := map[string]interface{}{}
+	json.Unmarshal(record.data, &entity)
+
+	m.notifications = append(m.notifications, &Notification{
+		UUID: newMemoryIdentifier(),
+		Events: EventsList{
+			&Event{
+				DataMap:         []map[string]interface{}{entity},
+				EntityType:      record.identity.Name,
+				Type:            eventType,
+				UpdateMechanism: UpdateMechanismDefault,
+			},
+		},
+	})
+
+	history := m.EventHistory
+	if history <= 0 {
+		history = memoryDefaultEventHistory
+	}
+
+	if trimmed := len(m.notifications) - history; trimmed > 0 {
+		m.origin = m.notifications[trimmed-1].UUID
+		m.notifications = append([]*Notification{}, m.notifications[trimmed:]...)
+	}
+
+	close(m.notify)
+	m.notify = make(chan struct{})
+}
+
+// notificationAfter returns a Notification containing all the events following the given
+// lastEventID, or nil if there is none. If lastEventID is empty, it returns a Notification
+// without events carrying the identifier of the last one. It must be called with the lock held.
+func (m *MemoryStorer) notificationAfter(lastEventID string) (*Notification, *Error) {
+
+	if lastEventID == "" {
+		notification := NewNotification()
+		notification.UUID = m.origin
+		if len(m.notifications) > 0 {
+			notification.UUID = m.notifications[len(m.notifications)-1].UUID
+		}
+		return notification, nil
+	}
+
+	start := -1
+	if lastEventID == m.origin {
+		start = 0
+	}
+
+	for i, n := range m.notifications {
+		if n.UUID == lastEventID {
+			start = i + 1
+			break
+		}
+	}
+
+	if start == -1 {
+		berr := NewBambouError("Unknown event", fmt.Sprintf("Cannot find event with UUID %s", lastEventID))
+		berr.Code = http.StatusBadRequest
+		return nil, berr
+	}
+
+	if start >= len(m.notifications) {
+		return nil, nil
+	}
+
+	notification := NewNotification()
+	for _, n := range m.notifications[start:] {
+		notification.Events = append(notification.Events, n.Events...)
+		notification.UUID = n.UUID
+	}
+
+	return notification, nil
+}
+
+// memoryKey returns the key used to store an object.
+func memoryKey(identity Identity, identifier string) string {
+
+	return identity.Name + "/" + identifier
+}
+
+// memoryRelation returns the key used to store the children of the given identity of a parent.
+func memoryRelation(parentKey string, identity Identity) string {
+
+	return parentKey + "|" + identity.Category
+}
+
+// newMemoryIdentifier returns a new random UUID.
+func newMemoryIdentifier() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// removeString returns the given list without the given value.
+func removeString(list []string, value string) []string {
+
+	out := list[:0]
+	for _, v := range list {
+		if v != value {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,419 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryStorer_NewMemoryStorer(t *testing.T) {
+
+	Convey("Given I create a new MemoryStorer", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		Convey("Then Root should be the given root", func() {
+			So(m.Root(), ShouldEqual, r)
+		})
+
+		Convey("When I start it", func() {
+
+			err := m.Start()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then it should be the current session", func() {
+				So(CurrentSession(), ShouldEqual, m)
+			})
+
+			Convey("When I reset it", func() {
+
+				m.Reset()
+
+				Convey("Then there should be no current session", func() {
+					So(CurrentSession(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestMemoryStorer_CRUD(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		Convey("When I create a child under the root", func() {
+
+			o := NewFakeObject("")
+			o.Name = "hello"
+			err := m.CreateChild(r, o)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the object should have an ID", func() {
+				So(o.ID, ShouldNotBeEmpty)
+			})
+
+			Convey("When I create it again", func() {
+
+				err := m.CreateChild(r, o)
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("When I fetch it", func() {
+
+				f := NewFakeObject(o.ID)
+				err := m.FetchEntity(f)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the name should be hello", func() {
+					So(f.Name, ShouldEqual, "hello")
+				})
+			})
+
+			Convey("When I save it with a new name", func() {
+
+				o.Name = "world"
+				err := m.SaveEntity(o)
+
+				f := NewFakeObject(o.ID)
+				m.FetchEntity(f)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the name should be world", func() {
+					So(f.Name, ShouldEqual, "world")
+				})
+			})
+
+			Convey("When I create a child under it and delete it", func() {
+
+				c := NewFakeObject("")
+				m.CreateChild(o, c)
+				err := m.DeleteEntity(o)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the object should be gone", func() {
+					So(m.FetchEntity(NewFakeObject(o.ID)), ShouldNotBeNil)
+				})
+
+				Convey("Then the child should be gone", func() {
+					So(m.FetchEntity(NewFakeObject(c.ID)), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When I fetch an object that doesn't exist", func() {
+
+			err := m.FetchEntity(NewFakeObject("nope"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I save an object that doesn't exist", func() {
+
+			err := m.SaveEntity(NewFakeObject("nope"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I delete an object that doesn't exist", func() {
+
+			err := m.DeleteEntity(NewFakeObject("nope"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create a child under an object that doesn't exist", func() {
+
+			err := m.CreateChild(NewFakeObject("nope"), NewFakeObject(""))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestMemoryStorer_FetchChildren(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer with a parent and 5 children", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		p := NewFakeObject("p")
+		m.CreateChild(r, p)
+		for _, id := range []string{"1", "2", "3", "4", "5"} {
+			m.CreateChild(p, NewFakeObject(id))
+		}
+
+		Convey("When I fetch the children without fetching info", func() {
+
+			var l FakeObjectsList
+			err := m.FetchChildren(p, FakeIdentity, &l, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get 5 children", func() {
+				So(len(l), ShouldEqual, 5)
+			})
+
+			Convey("Then the children should be in creation order", func() {
+				So(l[0].ID, ShouldEqual, "1")
+				So(l[4].ID, ShouldEqual, "5")
+			})
+		})
+
+		Convey("When I fetch the second page of 2 children", func() {
+
+			var l FakeObjectsList
+			info := &FetchingInfo{Page: 1, PageSize: 2}
+			err := m.FetchChildren(p, FakeIdentity, &l, info)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get the children 3 and 4", func() {
+				So(len(l), ShouldEqual, 2)
+				So(l[0].ID, ShouldEqual, "3")
+				So(l[1].ID, ShouldEqual, "4")
+			})
+
+			Convey("Then the total count should be 5", func() {
+				So(info.TotalCount, ShouldEqual, 5)
+			})
+		})
+
+		Convey("When I fetch a page after the last one", func() {
+
+			var l FakeObjectsList
+			info := &FetchingInfo{Page: 3, PageSize: 2}
+			err := m.FetchChildren(p, FakeIdentity, &l, info)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get no children", func() {
+				So(len(l), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I assign 2 children to another parent", func() {
+
+			o := NewFakeObject("o")
+			m.CreateChild(r, o)
+			err := m.AssignChildren(o, []Identifiable{NewFakeObject("1"), NewFakeObject("2")}, FakeIdentity)
+
+			var l FakeObjectsList
+			m.FetchChildren(o, FakeIdentity, &l, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get 2 assigned children", func() {
+				So(len(l), ShouldEqual, 2)
+			})
+
+			Convey("When I delete one of the assigned children", func() {
+
+				m.DeleteEntity(NewFakeObject("1"))
+
+				var l FakeObjectsList
+				m.FetchChildren(o, FakeIdentity, &l, nil)
+
+				Convey("Then I should get 1 assigned child", func() {
+					So(len(l), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When I assign a child that doesn't exist", func() {
+
+			err := m.AssignChildren(p, []Identifiable{NewFakeObject("nope")}, FakeIdentity)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestMemoryStorer_NextEvent(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		m.EventTimeout = 10 * time.Millisecond
+
+		c := make(NotificationsChannel, 1)
+		m.NextEvent(c, "")
+		origin := <-c
+
+		Convey("Then the first notification should have no events", func() {
+			So(origin.UUID, ShouldNotBeEmpty)
+			So(origin.Events, ShouldBeEmpty)
+		})
+
+		Convey("When I wait for an event and nothing happens", func() {
+
+			err := m.NextEvent(c, origin.UUID)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should not receive any notification", func() {
+				So(len(c), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I create and delete an object", func() {
+
+			o := NewFakeObject("")
+			m.CreateChild(r, o)
+			m.DeleteEntity(o)
+
+			err := m.NextEvent(c, origin.UUID)
+			n := <-c
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should receive a CREATE and a DELETE event", func() {
+				So(len(n.Events), ShouldEqual, 2)
+				So(n.Events[0].Type, ShouldEqual, EventTypeCreate)
+				So(n.Events[1].Type, ShouldEqual, EventTypeDelete)
+				So(n.Events[0].EntityType, ShouldEqual, "fake")
+				So(n.Events[0].DataMap[0]["ID"], ShouldEqual, o.ID)
+			})
+
+			Convey("When I wait for an event without UUID", func() {
+
+				m.NextEvent(c, "")
+				current := <-c
+
+				Convey("Then I should only receive the UUID of the last notification", func() {
+					So(current.UUID, ShouldEqual, n.UUID)
+					So(current.Events, ShouldBeEmpty)
+				})
+			})
+
+			Convey("When I wait for the next event with an unknown UUID", func() {
+
+				err := m.NextEvent(c, "nope")
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When more notifications than the history are published", func() {
+
+			m.EventHistory = 2
+			m.CreateChild(r, NewFakeObject("1"))
+			m.CreateChild(r, NewFakeObject("2"))
+
+			m.NextEvent(c, "")
+			second := <-c
+
+			m.CreateChild(r, NewFakeObject("3"))
+
+			Convey("Then the trimmed notifications should not be known anymore", func() {
+				err := m.NextEvent(c, origin.UUID)
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Then the kept notifications should still be sent", func() {
+				err := m.NextEvent(c, second.UUID)
+				So(err, ShouldBeNil)
+				n := <-c
+				So(len(n.Events), ShouldEqual, 1)
+				So(n.Events[0].DataMap[0]["ID"], ShouldEqual, "3")
+			})
+		})
+
+		Convey("When I use it with a PushCenter", func() {
+
+			m.EventTimeout = 0
+			received := make(chan *Event, 1)
+
+			p := NewPushCenter(m)
+			p.RegisterHandlerForIdentity(func(e *Event) { received <- e }, FakeIdentity)
+			startPushCenter(p)
+
+			m.CreateChild(r, NewFakeObject("x"))
+
+			var e *Event
+			select {
+			case e = <-received:
+			case <-time.After(time.Second):
+			}
+			p.Stop()
+
+			Convey("Then the handler should receive the event", func() {
+				So(e, ShouldNotBeNil)
+				So(e.Type, ShouldEqual, EventTypeCreate)
+			})
+		})
+	})
+}
@@ -23,6 +23,35 @@
 
 package bambou
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventType represents the type of an Event.
+//
+// Event.Type used to be a string. Comparing it with untyped string constants
+// like "CREATE" still compiles, but assigning it to a string or passing it as
+// a string argument now requires a conversion: string(event.Type).
+type EventType string
+
+// Supported values of EventType.
+const (
+	EventTypeCreate EventType = "CREATE"
+	EventTypeUpdate EventType = "UPDATE"
+	EventTypeDelete EventType = "DELETE"
+)
+
+// UpdateMechanism represents the way the server expects an updated entity to be handled.
+type UpdateMechanism string
+
+// Supported values of UpdateMechanism.
+const (
+	UpdateMechanismDefault          UpdateMechanism = "DEFAULT"
+	UpdateMechanismRefetch          UpdateMechanism = "REFETCH"
+	UpdateMechanismRefetchHierarchy UpdateMechanism = "REFETCH_HIERARCHY"
+)
+
 // EventsList represents a list of *Event.
 type EventsList []*Event
 
@@ -33,8 +62,123 @@ type Event struct {
 	DataMap         []map[string]interface{} `json:"entities"`
 	Data            []byte                   `json:"-"`
 	EntityType      string                   `json:"entityType"`
-	Type            string                   `json:"type"`
-	UpdateMechanism string                   `json:"updateMechanism"`
+	Type            EventType                `json:"type"`
+	UpdateMechanism UpdateMechanism          `json:"updateMechanism"`
+}
+
+// EntityID returns the identifier of the first entity of the Event.
+// Use EntityIDs for the Events containing several entities.
+func (e *Event) EntityID() string {
+
+	return e.entityAttribute(0, "ID")
+}
+
+// ParentID returns the identifier of the parent of the first entity of the Event.
+// Use ParentIDs for the Events containing several entities.
+func (e *Event) ParentID() string {
+
+	return e.entityAttribute(0, "parentID")
+}
+
+// EntityIDs returns the identifiers of all the entities of the Event.
+func (e *Event) EntityIDs() []string {
+
+	return e.entityAttributes("ID")
+}
+
+// ParentIDs returns the identifiers of the parents of all the entities of the Event.
+func (e *Event) ParentIDs() []string {
+
+	return e.entityAttributes("parentID")
+}
+
+// entityAttribute returns the given string attribute of the entity of the Event at the given index.
+func (e *Event) entityAttribute(index int, name string) string {
+
+	if index >= len(e.DataMap) {
+		return ""
+	}
+
+	value, _ := e.DataMap[index][name].(string)
+
+	return value
+}
+
+// entityAttributes returns the given string attribute of all the entities of the Event.
+func (e *Event) entityAttributes(name string) []string {
+
+	values := make([]string, len(e.DataMap))
+	for i := range e.DataMap {
+		values[i] = e.entityAttribute(i, name)
+	}
+
+	return values
+}
+
+// Decode decodes the first entity of the Event into the given Identifiable.
+// Use DecodeEntity or Entities for the Events containing several entities.
+func (e *Event) Decode(into Identifiable) *Error {
+
+	return e.DecodeEntity(0, into)
+}
+
+// DecodeEntity decodes the entity of the Event at the given index into the given Identifiable.
+func (e *Event) DecodeEntity(index int, into Identifiable) *Error {
+
+	if len(e.DataMap) == 0 {
+		return NewBambouError("Event error", "The event does not contain any entity")
+	}
+
+	if index < 0 || index >= len(e.DataMap) {
+		return NewBambouError("Event error", fmt.Sprintf("The event does not contain any entity at index %d", index))
+	}
+
+	return decodeEntity(e.DataMap[index], into)
+}
+
+// Entities returns all the entities of the Event decoded into new Identifiables
+// instantiated from the DefaultIdentityRegistry.
+func (e *Event) Entities() ([]Identifiable, *Error) {
+
+	return e.EntitiesFromRegistry(DefaultIdentityRegistry)
+}
+
+// EntitiesFromRegistry returns all the entities of the Event decoded into new Identifiables
+// instantiated from the given IdentityRegistry.
+func (e *Event) EntitiesFromRegistry(registry *IdentityRegistry) ([]Identifiable, *Error) {
+
+	entities := make([]Identifiable, len(e.DataMap))
+
+	for i, data := range e.DataMap {
+
+		entity := registry.NewFromName(e.EntityType)
+		if entity == nil {
+			return nil, NewBambouError("Event error", fmt.Sprintf("No registered identity named %s", e.EntityType))
+		}
+
+		if berr := decodeEntity(data, entity); berr != nil {
+			return nil, berr
+		}
+
+		entities[i] = entity
+	}
+
+	return entities, nil
+}
+
+// decodeEntity decodes the given entity data into the given Identifiable.
+func decodeEntity(data map[string]interface{}, into Identifiable) *Error {
+
+	buffer, err := json.Marshal(data)
+	if err != nil {
+		return NewBambouError("JSON error", err.Error())
+	}
+
+	if err := json.Unmarshal(buffer, into); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return nil
 }
 
 // Notification represents a collection of Event structures.
@@ -71,11 +71,11 @@ func TestNotification_FromJSON(t *testing.T) {
 				})
 
 				Convey("Then Type should UPDATE", func() {
-					So(e.Type, ShouldEqual, "UPDATE")
+					So(e.Type, ShouldEqual, EventTypeUpdate)
 				})
 
 				Convey("Then UpdateMechanism should useless", func() {
-					So(e.UpdateMechanism, ShouldEqual, "useless")
+					So(e.UpdateMechanism, ShouldEqual, UpdateMechanism("useless"))
 				})
 
 				Convey("Then the lenght of DataMap should be 1", func() {
@@ -89,3 +89,163 @@ func TestNotification_FromJSON(t *testing.T) {
 		})
 	})
 }
+
+func TestNotification_Decode(t *testing.T) {
+
+	Convey("Given I have an event with two entities", t, func() {
+
+		e := &Event{
+			EntityType: "fake",
+			Type:       EventTypeCreate,
+			DataMap: []map[string]interface{}{
+				{"ID": "1", "name": "one"},
+				{"ID": "2", "name": "two"},
+			},
+		}
+
+		Convey("When I decode it", func() {
+
+			o := NewFakeObject("")
+			err := e.Decode(o)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the object should be the first entity", func() {
+				So(o.ID, ShouldEqual, "1")
+				So(o.Name, ShouldEqual, "one")
+			})
+		})
+
+		Convey("When I decode its second entity", func() {
+
+			o := NewFakeObject("")
+			err := e.DecodeEntity(1, o)
+
+			Convey("Then the object should be the second entity", func() {
+				So(err, ShouldBeNil)
+				So(o.ID, ShouldEqual, "2")
+				So(o.Name, ShouldEqual, "two")
+			})
+		})
+
+		Convey("When I decode an entity out of range", func() {
+
+			err := e.DecodeEntity(2, NewFakeObject(""))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I decode it into an unmarshalable object", func() {
+
+			err := e.Decode(NewUnmarshalableFakeObject(""))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I retrieve the entities with a registry knowing the identity", func() {
+
+			r := NewIdentityRegistry()
+			r.Register(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+			entities, err := e.EntitiesFromRegistry(r)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get the two entities", func() {
+				So(len(entities), ShouldEqual, 2)
+				So(entities[0].(*FakeObject).Name, ShouldEqual, "one")
+				So(entities[1].(*FakeObject).Name, ShouldEqual, "two")
+			})
+		})
+
+		Convey("When I retrieve the entities with the default registry knowing the identity", func() {
+
+			RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+			defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+			entities, err := e.Entities()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then I should get the two entities", func() {
+				So(len(entities), ShouldEqual, 2)
+				So(entities[1].Identifier(), ShouldEqual, "2")
+			})
+		})
+
+		Convey("When I retrieve the entities with a registry not knowing the identity", func() {
+
+			entities, err := e.EntitiesFromRegistry(NewIdentityRegistry())
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then entities should be nil", func() {
+				So(entities, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have an event without entity", t, func() {
+
+		e := &Event{EntityType: "fake"}
+
+		Convey("When I decode it", func() {
+
+			err := e.Decode(NewFakeObject(""))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestNotification_EntityID(t *testing.T) {
+
+	Convey("Given I have an event with an entity", t, func() {
+
+		e := &Event{DataMap: []map[string]interface{}{{"ID": "xxx", "parentID": "yyy"}}}
+
+		Convey("Then EntityID should be xxx", func() {
+			So(e.EntityID(), ShouldEqual, "xxx")
+		})
+
+		Convey("Then ParentID should be yyy", func() {
+			So(e.ParentID(), ShouldEqual, "yyy")
+		})
+	})
+
+	Convey("Given I have an event with two entities", t, func() {
+
+		e := &Event{DataMap: []map[string]interface{}{{"ID": "1", "parentID": "p1"}, {"ID": "2", "parentID": "p2"}}}
+
+		Convey("Then EntityIDs should return both identifiers", func() {
+			So(e.EntityIDs(), ShouldResemble, []string{"1", "2"})
+		})
+
+		Convey("Then ParentIDs should return both parent identifiers", func() {
+			So(e.ParentIDs(), ShouldResemble, []string{"p1", "p2"})
+		})
+	})
+
+	Convey("Given I have an event without entity", t, func() {
+
+		e := &Event{}
+
+		Convey("Then EntityID and ParentID should be empty", func() {
+			So(e.EntityID(), ShouldBeEmpty)
+			So(e.ParentID(), ShouldBeEmpty)
+			So(e.EntityIDs(), ShouldBeEmpty)
+		})
+	})
+}
@@ -25,131 +25,492 @@ package bambou
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+// pushCenterErrorsBufferSize is the number of errors kept until they are received.
+const pushCenterErrorsBufferSize = 10
+
+// defaultPushCenterQueueSize is the default number of events waiting for each worker.
+const defaultPushCenterQueueSize = 100
+
 // NotificationsChannel is used to received notification from the session
 type NotificationsChannel chan *Notification
 
 // EventHandler is prototype of a Push Center Handler.
 type EventHandler func(*Event)
 
-// eventHandlers represents a map of EventHandler based on the identity.
-type eventHandlers map[string]EventHandler
+// CursorExpiredHandler is the prototype of the function called when the server
+// does not know the given cursor anymore. Events may have been lost, so the
+// local state should be fully synchronized again.
+type CursorExpiredHandler func(cursor string)
+
+// CancelableEventStorer is implemented by the Storers whose wait for the next
+// notification can be interrupted, so a PushCenter can be stopped immediately.
+type CancelableEventStorer interface {
+
+	// NextEventContext is like NextEvent, but it returns when the given context is done.
+	NextEventContext(ctx context.Context, channel NotificationsChannel, lastEventID string) *Error
+}
+
+// eventHandlers represents a map of Subscription based on the identity name.
+type eventHandlers map[string][]*Subscription
+
+// Subscription represents an EventHandler registered in a PushCenter.
+type Subscription struct {
+	filter     EventFilter
+	handler    EventHandler
+	pushCenter *PushCenter
+	watch      bool
+}
+
+// Identity returns the Identity the Subscription has been registered for.
+func (s *Subscription) Identity() Identity {
+
+	return s.filter.identity()
+}
+
+// Filter returns the EventFilter of the Subscription.
+func (s *Subscription) Filter() EventFilter {
+
+	return s.filter
+}
+
+// Cancel unregisters the Subscription from its PushCenter.
+// Calling Cancel more than once has no effect.
+func (s *Subscription) Cancel() {
+
+	s.pushCenter.unsubscribe(s)
+}
 
 // PushCenter is a structure that allows the user to deal with notifications.
 // You can register multiple handlers for several Identity. When a notification
 // is sent by the server and the Identity of its content matches one of the
 // registered handler, this handler will be called.
+// Handlers can be registered and unregistered at any time, including from
+// a running handler.
+//
+// Once started, the PushCenter waits for the notifications of its Storer one
+// at a time. When the Storer returns an error, the error is sent to the Errors
+// channel and the PushCenter waits before trying again, doubling the delay at
+// each consecutive error from MinBackoff up to MaxBackoff. If the error
+// indicates that the API key has expired, the Storer is authenticated again
+// if it implements the Reauthenticator interface.
+//
+// If Workers is set, the handlers are called by a pool of goroutines, so a
+// slow handler does not delay the other events. The events of a given entity
+// are always handled by the same worker, in the order they have been received.
+//
+// If a CursorStore is set, the PushCenter resumes from the saved cursor when
+// it starts and saves the identifier of each notification once all its events
+// have been handled. If the server does not know the cursor anymore, the
+// CursorExpiredHandler is called and the PushCenter continues from the
+// current notifications.
 type PushCenter struct {
 	isRunning bool
-	Channel   NotificationsChannel
 
-	handlers      eventHandlers
-	defaultHander EventHandler
-	stop          chan bool
-	session       *Session
+	// Channel is not used anymore and is only kept for compatibility.
+	Channel NotificationsChannel
+
+	// CursorStore is used to persist the identifier of the last handled notification.
+	CursorStore CursorStore
+
+	// CursorExpiredHandler is called when the cursor is not known by the server anymore.
+	CursorExpiredHandler CursorExpiredHandler
+
+	// Workers is the number of goroutines calling the handlers. If it is zero,
+	// the handlers are called by the goroutine waiting for the notifications.
+	Workers int
+
+	// QueueSize is the number of events waiting to be handled by each worker.
+	QueueSize int
+
+	// OverflowPolicy defines what happens when the queue of a worker is full.
+	OverflowPolicy OverflowPolicy
+
+	// MinBackoff is the delay before polling again after an error.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay before polling again after consecutive errors.
+	MaxBackoff time.Duration
+
+	handlers eventHandlers
+	errors   chan *Error
+	stop     chan bool
+	session  Storer
+	lock     sync.RWMutex
+	wg       sync.WaitGroup
 }
 
-// NewPushCenter creates a new PushCenter.
-func NewPushCenter(session *Session) *PushCenter {
+// NewPushCenter creates a new PushCenter receiving the notifications of the given Storer.
+func NewPushCenter(session Storer) *PushCenter {
 
 	return &PushCenter{
-		Channel:  make(NotificationsChannel),
-		stop:     make(chan bool),
-		handlers: eventHandlers{},
-		session:  session,
+		Channel:    make(NotificationsChannel),
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+		QueueSize:  defaultPushCenterQueueSize,
+		errors:     make(chan *Error, pushCenterErrorsBufferSize),
+		stop:       make(chan bool),
+		handlers:   eventHandlers{},
+		session:    session,
 	}
 }
 
+// Errors returns the channel receiving the errors that occur while waiting for
+// notifications. Errors are dropped if they are not received fast enough.
+func (p *PushCenter) Errors() <-chan *Error {
+
+	return p.errors
+}
+
 // RegisterHandlerForIdentity registers the given EventHandler for the given Entity Identity.
 // You can pass the bambou.AllIdentity as identity to register the handler
-// for all events. If you pass a handler for an Identity that is already registered
-// the previous handler will be silently overwriten.
-func (p *PushCenter) RegisterHandlerForIdentity(handler EventHandler, identity Identity) {
+// for all events. Several handlers can be registered for the same Identity:
+// they are called in the order they have been registered, after the ones
+// registered for bambou.AllIdentity. The returned Subscription can be used to
+// unregister the handler.
+func (p *PushCenter) RegisterHandlerForIdentity(handler EventHandler, identity Identity) *Subscription {
 
-	if identity.Name == AllIdentity.Name {
-		p.defaultHander = handler
-		return
+	return p.Subscribe(EventFilter{Identity: identity}, handler)
+}
+
+// Subscribe registers the given EventHandler for the Events matching the given EventFilter.
+// The handler is called in the same order as the ones registered with
+// RegisterHandlerForIdentity for the Identity of the EventFilter. The returned
+// Subscription can be used to unregister the handler.
+func (p *PushCenter) Subscribe(filter EventFilter, handler EventHandler) *Subscription {
+
+	return p.subscribe(filter, handler, false)
+}
+
+// subscribe registers the given EventHandler for the Events matching the given EventFilter.
+// The Subscriptions of a watch are only removed when the watch is done.
+func (p *PushCenter) subscribe(filter EventFilter, handler EventHandler, watch bool) *Subscription {
+
+	subscription := &Subscription{
+		filter:     filter,
+		handler:    handler,
+		pushCenter: p,
+		watch:      watch,
 	}
 
-	p.handlers[identity.Name] = handler
+	name := filter.identity().Name
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.handlers[name] = append(p.handlers[name], subscription)
+
+	return subscription
 }
 
-// UnregisterHandlerForIdentity unegisters the given EventHandler for the given Entity Identity.
+// UnregisterHandlerForIdentity unregisters all the EventHandlers registered for the given
+// Entity Identity with RegisterHandlerForIdentity or Subscribe. The channels returned by
+// Watch and WatchEntity keep receiving their changes.
 func (p *PushCenter) UnregisterHandlerForIdentity(identity Identity) {
 
-	if identity.Name == AllIdentity.Name {
-		p.defaultHander = nil
-		return
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var kept []*Subscription
+	for _, s := range p.handlers[identity.Name] {
+		if s.watch {
+			kept = append(kept, s)
+		}
 	}
 
-	if _, exists := p.handlers[identity.Name]; exists {
+	if len(kept) == 0 {
 		delete(p.handlers, identity.Name)
+		return
 	}
+
+	p.handlers[identity.Name] = kept
 }
 
 // HasHandlerForIdentity verifies if the given identity has a registered handler.
 func (p *PushCenter) HasHandlerForIdentity(identity Identity) bool {
 
-	if identity.Name == AllIdentity.Name {
-		return p.defaultHander != nil
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.handlers[identity.Name]) > 0
+}
+
+// unsubscribe removes the given Subscription.
+func (p *PushCenter) unsubscribe(subscription *Subscription) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	name := subscription.filter.identity().Name
+	subscriptions := p.handlers[name]
+
+	for i, s := range subscriptions {
+		if s == subscription {
+			p.handlers[name] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			break
+		}
 	}
-	_, exists := p.handlers[identity.Name]
-	return exists
+
+	if len(p.handlers[name]) == 0 {
+		delete(p.handlers, name)
+	}
+}
+
+// subscriptionsFor returns the Subscriptions matching the given entity type.
+func (p *PushCenter) subscriptionsFor(entityType string) []*Subscription {
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	subscriptions := append([]*Subscription{}, p.handlers[AllIdentity.Name]...)
+
+	return append(subscriptions, p.handlers[entityType]...)
 }
 
 // Start starts the Push Center.
 func (p *PushCenter) Start() error {
 
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	if p.isRunning {
 		return errors.New("the push center is already started")
 	}
 
 	p.isRunning = true
+	p.stop = make(chan bool)
 
-	go func() {
-		lastEventID := ""
-		for {
-			go p.session.NextEvent(p.Channel, lastEventID)
-			select {
-			case notification := <-p.Channel:
-				for _, event := range notification.Events {
-
-					buffer := &bytes.Buffer{}
-					if err := json.NewEncoder(buffer).Encode(event.DataMap[0]); err != nil {
-						continue
-					}
-					event.Data = buffer.Bytes()
-
-					lastEventID = notification.UUID
-					if p.defaultHander != nil {
-						p.defaultHander(event)
-					}
-
-					if handler, exists := p.handlers[event.EntityType]; exists {
-						handler(event)
-					}
-				}
-			case <-p.stop:
-				return
-			}
-		}
-	}()
+	p.wg.Add(1)
+	go p.listen(p.stop)
 
 	return nil
 }
 
-// Stop stops a running PushCenter.
+// Stop stops a running PushCenter. It waits for the handlers being called and for
+// the pending call to NextEvent to return, so it must not be called from a handler.
+// If the Storer implements CancelableEventStorer, the pending call is interrupted.
 func (p *PushCenter) Stop() error {
 
+	p.lock.Lock()
+
 	if !p.isRunning {
+		p.lock.Unlock()
 		return errors.New("the push center is not started")
 	}
 
-	p.stop <- true
+	close(p.stop)
 	p.isRunning = false
 
+	p.lock.Unlock()
+	p.wg.Wait()
+
 	return nil
 }
+
+// listen waits for the notifications and dispatches them until the given channel is closed.
+func (p *PushCenter) listen(stop chan bool) {
+
+	defer p.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lastEventID := p.loadCursor()
+	backoff := time.Duration(0)
+
+	var d *dispatcher
+	if p.Workers > 0 {
+		d = newDispatcher(p, stop)
+		defer d.wait()
+	}
+
+	for {
+
+		channel := make(NotificationsChannel, 1)
+		done := make(chan *Error, 1)
+
+		p.wg.Add(1)
+		go func(lastEventID string) {
+			defer p.wg.Done()
+			done <- p.nextEvent(ctx, channel, lastEventID)
+		}(lastEventID)
+
+		var berr *Error
+		select {
+		case berr = <-done:
+		case <-stop:
+			return
+		}
+
+		if berr != nil {
+
+			p.report(berr)
+
+			if lastEventID != "" && isCursorExpired(berr) {
+				if p.CursorExpiredHandler != nil {
+					p.CursorExpiredHandler(lastEventID)
+				}
+				lastEventID = ""
+				if d != nil {
+					d.reset()
+				}
+				p.saveCursor(lastEventID)
+				continue
+			}
+
+			if authenticator, ok := p.session.(Reauthenticator); ok && berr.Code == http.StatusUnauthorized {
+				if berr := authenticator.Reauthenticate(); berr != nil {
+					p.report(berr)
+				}
+			}
+
+			backoff = p.nextBackoff(backoff)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+
+			continue
+		}
+
+		backoff = 0
+
+		select {
+		case notification := <-channel:
+			lastEventID = notification.UUID
+			if d != nil {
+				d.dispatch(notification)
+			} else {
+				p.dispatch(notification)
+				p.saveCursor(lastEventID)
+			}
+		default:
+		}
+	}
+}
+
+// nextEvent waits for the next notification of the Storer, until the given context is done
+// if the Storer implements CancelableEventStorer.
+func (p *PushCenter) nextEvent(ctx context.Context, channel NotificationsChannel, lastEventID string) *Error {
+
+	if storer, ok := p.session.(CancelableEventStorer); ok {
+		return storer.NextEventContext(ctx, channel, lastEventID)
+	}
+
+	return p.session.NextEvent(channel, lastEventID)
+}
+
+// dispatch calls the handlers matching the events of the given Notification.
+func (p *PushCenter) dispatch(notification *Notification) {
+
+	for _, event := range notification.Events {
+		if prepareEvent(event) {
+			p.handle(event)
+		}
+	}
+}
+
+// handle calls the handlers matching the given Event.
+func (p *PushCenter) handle(event *Event) {
+
+	for _, subscription := range p.subscriptionsFor(event.EntityType) {
+		if subscription.filter.Matches(event) {
+			subscription.handler(event)
+		}
+	}
+}
+
+// prepareEvent sets the Data of the given Event from its first entity.
+// It returns false if the entity cannot be encoded.
+func prepareEvent(event *Event) bool {
+
+	if len(event.DataMap) == 0 {
+		return true
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := json.NewEncoder(buffer).Encode(event.DataMap[0]); err != nil {
+		return false
+	}
+	event.Data = buffer.Bytes()
+
+	return true
+}
+
+// loadCursor returns the cursor from the CursorStore, if any.
+func (p *PushCenter) loadCursor() string {
+
+	if p.CursorStore == nil {
+		return ""
+	}
+
+	cursor, err := p.CursorStore.LoadCursor()
+	if err != nil {
+		p.report(NewBambouError("Cursor error", err.Error()))
+		return ""
+	}
+
+	return cursor
+}
+
+// saveCursor saves the given cursor in the CursorStore, if any.
+func (p *PushCenter) saveCursor(cursor string) {
+
+	if p.CursorStore == nil {
+		return
+	}
+
+	if err := p.CursorStore.SaveCursor(cursor); err != nil {
+		p.report(NewBambouError("Cursor error", err.Error()))
+	}
+}
+
+// isCursorExpired returns true if the given error indicates that the server
+// does not know the requested notification identifier: the server answers
+// with 410, or with 400 and an error about the event or its UUID.
+func isCursorExpired(berr *Error) bool {
+
+	if berr.Code == http.StatusGone {
+		return true
+	}
+
+	if berr.Code != http.StatusBadRequest {
+		return false
+	}
+
+	message := strings.ToLower(berr.Title + " " + berr.Description)
+
+	return strings.Contains(message, "event") || strings.Contains(message, "uuid")
+}
+
+// report sends the given error to the errors channel, unless it is full.
+func (p *PushCenter) report(berr *Error) {
+
+	select {
+	case p.errors <- berr:
+	default:
+	}
+}
+
+// nextBackoff returns the delay to wait after an error, given the previous one.
+func (p *PushCenter) nextBackoff(backoff time.Duration) time.Duration {
+
+	if backoff == 0 {
+		return p.MinBackoff
+	}
+
+	if backoff *= 2; backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
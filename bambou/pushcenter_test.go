@@ -30,6 +30,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -67,14 +68,18 @@ func TestPushCenter_HandlersRegistration(t *testing.T) {
 		h := func(*Event) {}
 
 		Convey("When I register the handler for an identity", func() {
-			p.RegisterHandlerForIdentity(h, FakeIdentity)
+			s := p.RegisterHandlerForIdentity(h, FakeIdentity)
 
 			Convey("Then it should be registered in the list for that identity", func() {
 				So(p.HasHandlerForIdentity(FakeIdentity), ShouldBeTrue)
 			})
 
-			Convey("Then the default handler should be nil", func() {
-				So(p.defaultHander, ShouldBeNil)
+			Convey("Then the subscription should have the identity", func() {
+				So(s.Identity(), ShouldResemble, FakeIdentity)
+			})
+
+			Convey("Then no handler should be registered for the all identity", func() {
+				So(p.HasHandlerForIdentity(AllIdentity), ShouldBeFalse)
 			})
 
 			Convey("When I unregister the handler for that identity", func() {
@@ -84,9 +89,41 @@ func TestPushCenter_HandlersRegistration(t *testing.T) {
 				Convey("Then it should not be registered in the list anymore", func() {
 					So(p.HasHandlerForIdentity(FakeIdentity), ShouldBeFalse)
 				})
+			})
+
+			Convey("When I cancel the subscription", func() {
+
+				s.Cancel()
+
+				Convey("Then it should not be registered in the list anymore", func() {
+					So(p.HasHandlerForIdentity(FakeIdentity), ShouldBeFalse)
+				})
+
+				Convey("When I cancel it again", func() {
+
+					s.Cancel()
+
+					Convey("Then it should still not be registered", func() {
+						So(p.HasHandlerForIdentity(FakeIdentity), ShouldBeFalse)
+					})
+				})
+			})
+
+			Convey("When I register a second handler for the same identity", func() {
+
+				s2 := p.RegisterHandlerForIdentity(h, FakeIdentity)
+
+				Convey("Then both handlers should be registered", func() {
+					So(len(p.subscriptionsFor(FakeIdentity.Name)), ShouldEqual, 2)
+				})
+
+				Convey("When I cancel the first subscription", func() {
+
+					s.Cancel()
 
-				Convey("Then the default handler should be nil", func() {
-					So(p.defaultHander, ShouldBeNil)
+					Convey("Then only the second handler should be registered", func() {
+						So(p.subscriptionsFor(FakeIdentity.Name), ShouldResemble, []*Subscription{s2})
+					})
 				})
 			})
 		})
@@ -94,12 +131,12 @@ func TestPushCenter_HandlersRegistration(t *testing.T) {
 		Convey("When I register handler for the all identity", func() {
 			p.RegisterHandlerForIdentity(h, AllIdentity)
 
-			Convey("Then it should not be registered in the list", func() {
+			Convey("Then it should be registered for the all identity", func() {
 				So(p.HasHandlerForIdentity(AllIdentity), ShouldBeTrue)
 			})
 
-			Convey("Then it should be set as the defaultHandler", func() {
-				So(p.defaultHander, ShouldEqual, h)
+			Convey("Then it should be returned for any identity", func() {
+				So(len(p.subscriptionsFor(FakeIdentity.Name)), ShouldEqual, 1)
 			})
 
 			Convey("When I unregister the handler for the all identity", func() {
@@ -109,14 +146,45 @@ func TestPushCenter_HandlersRegistration(t *testing.T) {
 					So(p.HasHandlerForIdentity(AllIdentity), ShouldBeFalse)
 				})
 
-				Convey("Then it should not be set as the defaultHandler anymore", func() {
-					So(p.defaultHander, ShouldBeNil)
+				Convey("Then it should not be returned for any identity anymore", func() {
+					So(len(p.subscriptionsFor(FakeIdentity.Name)), ShouldEqual, 0)
 				})
 			})
 		})
 	})
 }
 
+func TestPushCenter_ConcurrentRegistration(t *testing.T) {
+
+	Convey("Given I have a started PushCenter receiving events", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+		p.Start()
+
+		Convey("When I register and cancel handlers while events are dispatched", func() {
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s := p.RegisterHandlerForIdentity(func(*Event) {}, FakeIdentity)
+					m.CreateChild(r, NewFakeObject(""))
+					s.Cancel()
+				}()
+			}
+			wg.Wait()
+			p.Stop()
+
+			Convey("Then no handler should be registered anymore", func() {
+				So(p.HasHandlerForIdentity(FakeIdentity), ShouldBeFalse)
+			})
+		})
+	})
+}
+
 func TestPushCenter_Start(t *testing.T) {
 
 	Convey("Given I create a new PushCenter and resgister a handler", t, func() {
@@ -253,3 +321,436 @@ func TestPushCenter_Stop(t *testing.T) {
 		})
 	})
 }
+
+func TestPushCenter_Errors(t *testing.T) {
+
+	Convey("Given I have a PushCenter polling a server returning errors", t, func() {
+
+		var lock sync.Mutex
+		polls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			polls++
+			lock.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+
+		p := NewPushCenter(session)
+		p.MinBackoff = 20 * time.Millisecond
+		p.MaxBackoff = 40 * time.Millisecond
+		p.Start()
+
+		Convey("When I wait for the errors", func() {
+
+			var errs []*Error
+			for i := 0; i < 3; i++ {
+				select {
+				case err := <-p.Errors():
+					errs = append(errs, err)
+				case <-time.After(time.Second):
+				}
+			}
+			p.Stop()
+
+			Convey("Then I should receive the errors", func() {
+				So(len(errs), ShouldEqual, 3)
+				So(errs[0].Code, ShouldEqual, http.StatusInternalServerError)
+			})
+
+			Convey("Then the server should not have been polled more than once per error", func() {
+				lock.Lock()
+				defer lock.Unlock()
+				So(polls, ShouldBeLessThanOrEqualTo, 4)
+			})
+		})
+	})
+}
+
+func TestPushCenter_Backoff(t *testing.T) {
+
+	Convey("Given I have a PushCenter", t, func() {
+
+		p := NewPushCenter(nil)
+		p.MinBackoff = time.Second
+		p.MaxBackoff = 3 * time.Second
+
+		Convey("Then the backoff should double up to the maximum", func() {
+			So(p.nextBackoff(0), ShouldEqual, time.Second)
+			So(p.nextBackoff(time.Second), ShouldEqual, 2*time.Second)
+			So(p.nextBackoff(2*time.Second), ShouldEqual, 3*time.Second)
+			So(p.nextBackoff(3*time.Second), ShouldEqual, 3*time.Second)
+		})
+	})
+}
+
+func TestPushCenter_Reauthentication(t *testing.T) {
+
+	Convey("Given I have a PushCenter polling a server with an expired token", t, func() {
+
+		var lock sync.Mutex
+		authenticated := false
+		concurrent, maxConcurrent := 0, 0
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			lock.Lock()
+			concurrent++
+			if concurrent > maxConcurrent {
+				maxConcurrent = concurrent
+			}
+			lock.Unlock()
+
+			defer func() {
+				lock.Lock()
+				concurrent--
+				lock.Unlock()
+			}()
+
+			lock.Lock()
+			isAuthenticated := authenticated
+			lock.Unlock()
+
+			switch {
+			case r.URL.Path == "/root":
+				lock.Lock()
+				authenticated = true
+				lock.Unlock()
+				fmt.Fprint(w, `[{"ID": "root", "APIKey": "new-key"}]`)
+			case !isAuthenticated:
+				w.WriteHeader(http.StatusUnauthorized)
+			default:
+				time.Sleep(10 * time.Millisecond)
+				fmt.Fprint(w, `{"uuid": "x", "events": [{"type": "CREATE", "entityType": "fake", "updateMechanism": "DEFAULT", "entities": [{"ID": "x"}]}]}`)
+			}
+		}))
+		defer ts.Close()
+
+		r := NewFakeRootObject()
+		r.SetAPIKey("expired-key")
+		session := NewSession("username", "password", "organization", ts.URL, r)
+		session.saveValidators("validated", &flightResponse{header: http.Header{"Etag": {`"v1"`}}})
+
+		received := make(chan *Event, 10)
+		p := NewPushCenter(session)
+		p.MinBackoff = 10 * time.Millisecond
+		p.RegisterHandlerForIdentity(func(e *Event) {
+			select {
+			case received <- e:
+			default:
+			}
+		}, FakeIdentity)
+		p.Start()
+
+		Convey("When I wait for an event", func() {
+
+			var e *Event
+			select {
+			case e = <-received:
+			case <-time.After(2 * time.Second):
+			}
+
+			var berr *Error
+			select {
+			case berr = <-p.Errors():
+			default:
+			}
+
+			p.Stop()
+
+			Convey("Then I should receive the event", func() {
+				So(e, ShouldNotBeNil)
+			})
+
+			Convey("Then the expired token error should have been reported", func() {
+				So(berr, ShouldNotBeNil)
+				So(berr.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+
+			Convey("Then the session should have a new API key", func() {
+				So(r.APIKey(), ShouldEqual, "new-key")
+			})
+
+			Convey("Then the session should not have been reset", func() {
+				So(len(session.validators), ShouldEqual, 1)
+			})
+
+			Convey("Then the server should have received one request at a time", func() {
+				lock.Lock()
+				defer lock.Unlock()
+				So(maxConcurrent, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestPushCenter_StopWhileWaiting(t *testing.T) {
+
+	Convey("Given I have a PushCenter waiting for a notification", t, func() {
+
+		r := NewFakeRootObject()
+		p := NewPushCenter(NewMemoryStorer(r))
+		p.Start()
+
+		Convey("When I stop it", func() {
+
+			stopped := make(chan error, 1)
+			go func() { stopped <- p.Stop() }()
+
+			var err error
+			select {
+			case err = <-stopped:
+			case <-time.After(time.Second):
+				err = fmt.Errorf("stop is blocked")
+			}
+
+			Convey("Then it should stop without error", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("When I start it again", func() {
+
+				err := p.Start()
+				defer p.Stop()
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+// uncancelableStorer is a Storer whose NextEvent cannot be interrupted.
+// It counts the calls to NextEvent that have not returned yet.
+type uncancelableStorer struct {
+	Storer
+	pending int32
+}
+
+func (s *uncancelableStorer) NextEvent(channel NotificationsChannel, lastEventID string) *Error {
+
+	atomic.AddInt32(&s.pending, 1)
+	defer atomic.AddInt32(&s.pending, -1)
+
+	return s.Storer.NextEvent(channel, lastEventID)
+}
+
+func TestPushCenter_StopWithUncancelableStorer(t *testing.T) {
+
+	Convey("Given I have a PushCenter waiting for a notification that cannot be interrupted", t, func() {
+
+		m := NewMemoryStorer(NewFakeRootObject())
+		m.EventTimeout = 50 * time.Millisecond
+
+		s := &uncancelableStorer{Storer: m}
+		p := NewPushCenter(s)
+		p.Start()
+		time.Sleep(10 * time.Millisecond)
+
+		Convey("When I stop it", func() {
+
+			p.Stop()
+
+			Convey("Then the pending call to NextEvent should have returned", func() {
+				So(atomic.LoadInt32(&s.pending), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestPushCenter_isCursorExpired(t *testing.T) {
+
+	Convey("Given I have errors returned while waiting for notifications", t, func() {
+
+		newError := func(title string, description string, code int) *Error {
+			berr := NewBambouError(title, description)
+			berr.Code = code
+			return berr
+		}
+
+		Convey("Then the errors about an unknown event should be expired cursors", func() {
+			So(isCursorExpired(newError("Unknown event", "Cannot find event with UUID x", http.StatusBadRequest)), ShouldBeTrue)
+			So(isCursorExpired(newError("HTTP error", "410 Gone", http.StatusGone)), ShouldBeTrue)
+		})
+
+		Convey("Then the other errors should not be expired cursors", func() {
+			So(isCursorExpired(newError("HTTP error", "400 Bad Request", http.StatusBadRequest)), ShouldBeFalse)
+			So(isCursorExpired(newError("Invalid filter", "The filter is not valid", http.StatusBadRequest)), ShouldBeFalse)
+			So(isCursorExpired(newError("HTTP error", "500 Internal Server Error", http.StatusInternalServerError)), ShouldBeFalse)
+		})
+	})
+}
+
+// startPushCenter starts the given PushCenter and waits until it has received the
+// current cursor, so it receives all the events that happen after the call.
+func startPushCenter(p *PushCenter) {
+
+	if p.CursorStore == nil {
+		p.CursorStore = NewMemoryCursorStore()
+	}
+
+	previous, _ := p.CursorStore.LoadCursor()
+	p.Start()
+
+	if previous == "" {
+		waitForCursor(p.CursorStore, "")
+	}
+}
+
+// waitForCursor waits up to a second for the given CursorStore to contain a cursor other than the given one.
+func waitForCursor(cursors CursorStore, previous string) {
+
+	for i := 0; i < 100; i++ {
+		if cursor, _ := cursors.LoadCursor(); cursor != "" && cursor != previous {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPushCenter_CursorStore(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer with two notifications and a cursor on the first one", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		m.CreateChild(r, NewFakeObject("1"))
+		c := make(NotificationsChannel, 1)
+		m.NextEvent(c, "")
+		first := (<-c).UUID
+
+		m.CreateChild(r, NewFakeObject("2"))
+
+		cursors := NewMemoryCursorStore()
+		cursors.SaveCursor(first)
+
+		received := make(chan *Event, 10)
+		p := NewPushCenter(m)
+		p.CursorStore = cursors
+		p.RegisterHandlerForIdentity(func(e *Event) { received <- e }, FakeIdentity)
+
+		Convey("When I start the push center", func() {
+
+			p.Start()
+
+			var e *Event
+			select {
+			case e = <-received:
+			case <-time.After(time.Second):
+			}
+			p.Stop()
+
+			Convey("Then I should only receive the event following the cursor", func() {
+				So(e, ShouldNotBeNil)
+				So(e.DataMap[0]["ID"], ShouldEqual, "2")
+				So(len(received), ShouldEqual, 0)
+			})
+
+			Convey("Then the cursor should have been saved", func() {
+				cursor, _ := cursors.LoadCursor()
+				So(cursor, ShouldNotEqual, first)
+				So(cursor, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When I start the push center with an expired cursor", func() {
+
+			cursors.SaveCursor("expired")
+
+			expired := make(chan string, 1)
+			p.CursorExpiredHandler = func(cursor string) { expired <- cursor }
+			p.Start()
+
+			var cursor string
+			select {
+			case cursor = <-expired:
+			case <-time.After(time.Second):
+			}
+
+			waitForCursor(cursors, "expired")
+			m.CreateChild(r, NewFakeObject("3"))
+
+			var events []*Event
+			for len(events) < 3 {
+				select {
+				case e := <-received:
+					events = append(events, e)
+					continue
+				case <-time.After(200 * time.Millisecond):
+				}
+				break
+			}
+			p.Stop()
+
+			Convey("Then the cursor expired handler should have been called", func() {
+				So(cursor, ShouldEqual, "expired")
+			})
+
+			Convey("Then I should only receive the events following the current notification", func() {
+				So(len(events), ShouldEqual, 1)
+				So(events[0].DataMap[0]["ID"], ShouldEqual, "3")
+			})
+
+			Convey("Then the error should have been reported", func() {
+				berr := <-p.Errors()
+				So(berr.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestPushCenter_Subscribe(t *testing.T) {
+
+	Convey("Given I have a PushCenter on a MemoryStorer with filtered subscriptions", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+
+		created := make(chan *Event, 10)
+		deleted := make(chan *Event, 10)
+		all := make(chan *Event, 10)
+
+		p.Subscribe(EventFilter{Identity: FakeIdentity, Types: []EventType{EventTypeCreate}}, func(e *Event) { created <- e })
+		p.Subscribe(EventFilter{Types: []EventType{EventTypeDelete}, EntityID: "2"}, func(e *Event) { deleted <- e })
+		s := p.Subscribe(EventFilter{}, func(e *Event) { all <- e })
+
+		Convey("Then the subscription without identity should be registered for the all identity", func() {
+			So(s.Identity(), ShouldResemble, AllIdentity)
+			So(p.HasHandlerForIdentity(AllIdentity), ShouldBeTrue)
+		})
+
+		Convey("When I create and delete two objects", func() {
+
+			startPushCenter(p)
+
+			o1 := NewFakeObject("1")
+			o2 := NewFakeObject("2")
+			m.CreateChild(r, o1)
+			m.CreateChild(r, o2)
+			m.DeleteEntity(o1)
+			m.DeleteEntity(o2)
+
+			for i := 0; i < 4; i++ {
+				select {
+				case <-all:
+				case <-time.After(time.Second):
+				}
+			}
+			p.Stop()
+
+			Convey("Then the create handler should have received two events", func() {
+				So(len(created), ShouldEqual, 2)
+			})
+
+			Convey("Then the delete handler should have received the event of the second object", func() {
+				So(len(deleted), ShouldEqual, 1)
+				So((<-deleted).EntityID(), ShouldEqual, "2")
+			})
+		})
+	})
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReconcileActionType represents the type of a ReconcileAction.
+type ReconcileActionType string
+
+// Supported values of ReconcileActionType.
+const (
+	ReconcileActionCreate ReconcileActionType = "create"
+	ReconcileActionUpdate ReconcileActionType = "update"
+	ReconcileActionDelete ReconcileActionType = "delete"
+	ReconcileActionAssign ReconcileActionType = "assign"
+)
+
+// DesiredNode represents the desired state of an Identifiable, its children and its assignments.
+type DesiredNode struct {
+
+	// Object is the desired Identifiable. The Object of the root node must already exist.
+	Object Identifiable
+
+	// Children are the desired children of the Object.
+	Children []*DesiredNode
+
+	// Assignments are the desired assignments of the Object.
+	Assignments []*DesiredAssignment
+
+	// ManagedIdentities are the Identities of children that are pruned even if no child
+	// with that Identity is desired.
+	ManagedIdentities []Identity
+}
+
+// DesiredAssignment represents the Identifiables that must be assigned to a parent.
+// The Identifiables can be Objects of DesiredNodes not created yet.
+type DesiredAssignment struct {
+	Identity Identity
+	Objects  []Identifiable
+}
+
+// ReconcileAction represents an operation needed to reach the desired state.
+type ReconcileAction struct {
+	Type     ReconcileActionType
+	Parent   Identifiable
+	Object   Identifiable
+	Identity Identity
+	Objects  []Identifiable
+	Changes  []string
+
+	// Key is the natural key of the Object, or of the Parent for assignments.
+	Key string
+}
+
+// String returns the string representation of the ReconcileAction.
+func (a *ReconcileAction) String() string {
+
+	switch a.Type {
+	case ReconcileActionAssign:
+		return fmt.Sprintf("assign %d %s to %s", len(a.Objects), a.Identity.Category, describeKeyed(a.Parent, a.Key))
+	case ReconcileActionUpdate:
+		return fmt.Sprintf("update %s (%s)", describeKeyed(a.Object, a.Key), strings.Join(a.Changes, ", "))
+	default:
+		return fmt.Sprintf("%s %s", a.Type, describeKeyed(a.Object, a.Key))
+	}
+}
+
+// ReconcilePlan represents the list of ReconcileActions needed to reach the desired state.
+type ReconcilePlan []*ReconcileAction
+
+// Reconciler computes and applies the operations needed to make the objects of a Storer
+// match a tree of DesiredNodes. Desired children are matched to the existing ones
+// with the same Identity and the same key. Matched children are updated if one of the
+// attributes set in the desired object differs, and the others are created.
+//
+// The actions are applied in dependency order: creations and updates from the root
+// to the leaves, then assignments, then deletions.
+type Reconciler struct {
+
+	// KeyFunc returns the natural key of an Identifiable. By default, the name is used.
+	KeyFunc func(Identifiable) string
+
+	// Prune deletes the existing children that are not desired, for the Identities
+	// of the desired children and the ManagedIdentities.
+	Prune bool
+
+	// Rollback cancels the applied actions if an action fails.
+	Rollback bool
+
+	storer Storer
+}
+
+// NewReconciler returns a new *Reconciler using the given Storer.
+func NewReconciler(storer Storer) *Reconciler {
+
+	return &Reconciler{
+		storer: storer,
+	}
+}
+
+// Plan returns the actions needed to reach the given desired state, without applying them.
+func (r *Reconciler) Plan(desired *DesiredNode) (ReconcilePlan, *Error) {
+
+	return r.plan(desired, false)
+}
+
+// Apply computes and applies the actions needed to reach the given desired state.
+// The identifiers of the desired objects are set from the existing objects they match.
+// It returns the computed plan, and the error of the first failing action.
+func (r *Reconciler) Apply(desired *DesiredNode) (ReconcilePlan, *Error) {
+
+	plan, berr := r.plan(desired, true)
+	if berr != nil {
+		return nil, berr
+	}
+
+	if r.Rollback {
+		return plan, RunTransaction(r.storer, func(t *Transaction) *Error {
+			return applyPlan(plan, t.CreateChild, t.SaveEntity, t.DeleteChild, t.AssignChildren)
+		})
+	}
+
+	deleteChild := func(parent Identifiable, child Identifiable) *Error { return r.storer.DeleteEntity(child) }
+
+	return plan, applyPlan(plan, r.storer.CreateChild, r.storer.SaveEntity, deleteChild, r.storer.AssignChildren)
+}
+
+// reconcilePlanner holds the state of the computation of a ReconcilePlan.
+type reconcilePlanner struct {
+	reconciler  *Reconciler
+	apply       bool
+	matches     map[Identifiable]string
+	changes     ReconcilePlan
+	assignments ReconcilePlan
+	deletions   ReconcilePlan
+}
+
+// plan computes the ReconcilePlan of the given desired state. If apply is true,
+// the identifiers of the matched desired objects are set.
+func (r *Reconciler) plan(desired *DesiredNode, apply bool) (ReconcilePlan, *Error) {
+
+	if desired == nil || desired.Object == nil {
+		return nil, NewBambouError("Reconcile error", "The desired state has no root object")
+	}
+
+	if _, isRoot := desired.Object.(Rootable); !isRoot && desired.Object.Identifier() == "" {
+		return nil, NewBambouError("Reconcile error", "The root object of the desired state must have an ID")
+	}
+
+	p := &reconcilePlanner{
+		reconciler: r,
+		apply:      apply,
+		matches:    map[Identifiable]string{},
+	}
+
+	if berr := p.visit(desired, desired.Object, true); berr != nil {
+		return nil, berr
+	}
+
+	plan := append(append(p.changes, p.assignments...), p.deletions...)
+
+	return plan, nil
+}
+
+// visit computes the actions of the children and assignments of the given node.
+// The given reference identifies the object of the node in the Storer, and exists
+// is false if the object will only be created when applying the plan.
+func (p *reconcilePlanner) visit(node *DesiredNode, reference Identifiable, exists bool) *Error {
+
+	identities, grouped := groupDesiredChildren(node)
+
+	for _, identity := range identities {
+
+		var actual []Identifiable
+		var raw []json.RawMessage
+
+		if exists {
+			var berr *Error
+			if raw, berr = fetchAllRaw(p.reconciler.storer, reference, identity); berr != nil {
+				return berr
+			}
+
+			if actual, berr = decodeLike(raw, identity, grouped[identity]); berr != nil {
+				return berr
+			}
+		}
+
+		byKey := map[string]int{}
+		for i, object := range actual {
+			byKey[p.reconciler.key(object)] = i
+		}
+
+		matched := map[int]bool{}
+
+		for _, child := range grouped[identity] {
+
+			index, found := byKey[p.reconciler.key(child.Object)]
+			if !found || matched[index] {
+				p.changes = append(p.changes, &ReconcileAction{
+					Type:     ReconcileActionCreate,
+					Parent:   node.Object,
+					Object:   child.Object,
+					Identity: identity,
+					Key:      p.reconciler.key(child.Object),
+				})
+
+				if berr := p.visit(child, child.Object, false); berr != nil {
+					return berr
+				}
+
+				continue
+			}
+
+			matched[index] = true
+			existing := actual[index]
+			p.matches[child.Object] = existing.Identifier()
+
+			if p.apply {
+				child.Object.SetIdentifier(existing.Identifier())
+			}
+
+			changes, berr := diffDesired(child.Object, raw[index])
+			if berr != nil {
+				return berr
+			}
+
+			if len(changes) > 0 {
+				p.changes = append(p.changes, &ReconcileAction{
+					Type:     ReconcileActionUpdate,
+					Parent:   node.Object,
+					Object:   child.Object,
+					Identity: identity,
+					Changes:  changes,
+					Key:      p.reconciler.key(child.Object),
+				})
+			}
+
+			if berr := p.visit(child, existing, true); berr != nil {
+				return berr
+			}
+		}
+
+		if !p.reconciler.Prune {
+			continue
+		}
+
+		for i, object := range actual {
+			if !matched[i] {
+				p.deletions = append(p.deletions, &ReconcileAction{
+					Type:     ReconcileActionDelete,
+					Parent:   node.Object,
+					Object:   object,
+					Identity: identity,
+					Key:      p.reconciler.key(object),
+				})
+			}
+		}
+	}
+
+	for _, assignment := range node.Assignments {
+
+		if exists {
+			assigned, berr := fetchAllIdentifiers(p.reconciler.storer, reference, assignment.Identity)
+			if berr != nil {
+				return berr
+			}
+
+			if sameIdentifiers(assigned, assignment.Objects, p.matches) {
+				continue
+			}
+		}
+
+		p.assignments = append(p.assignments, &ReconcileAction{
+			Type:     ReconcileActionAssign,
+			Parent:   node.Object,
+			Identity: assignment.Identity,
+			Objects:  assignment.Objects,
+			Key:      p.reconciler.key(node.Object),
+		})
+	}
+
+	return nil
+}
+
+// key returns the natural key of the given Identifiable.
+func (r *Reconciler) key(object Identifiable) string {
+
+	return naturalKey(object, r.KeyFunc)
+}
+
+// naturalKey returns the natural key of the given Identifiable using the given
+// function, or its name if the function is nil.
+func naturalKey(object Identifiable, keyFunc func(Identifiable) string) string {
+
+	if keyFunc != nil {
+		return keyFunc(object)
+	}
+
+	if values := AttributeIndexer("name")(object); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// describeKeyed returns a short description of the given Identifiable using the given natural key.
+func describeKeyed(object Identifiable, key string) string {
+
+	if key == "" {
+		return describeIdentifiable(object)
+	}
+
+	return describeKey(object.Identity().Name, key)
+}
+
+// applyPlan applies the given plan using the given operations.
+func applyPlan(
+	plan ReconcilePlan,
+	createChild func(Identifiable, Identifiable) *Error,
+	saveEntity func(Identifiable) *Error,
+	deleteChild func(Identifiable, Identifiable) *Error,
+	assignChildren func(Identifiable, []Identifiable, Identity) *Error,
+) *Error {
+
+	for _, action := range plan {
+
+		var berr *Error
+
+		switch action.Type {
+		case ReconcileActionCreate:
+			berr = createChild(action.Parent, action.Object)
+		case ReconcileActionUpdate:
+			berr = saveEntity(action.Object)
+		case ReconcileActionDelete:
+			berr = deleteChild(action.Parent, action.Object)
+		case ReconcileActionAssign:
+			berr = assignChildren(action.Parent, action.Objects, action.Identity)
+		}
+
+		if berr != nil {
+			return berr
+		}
+	}
+
+	return nil
+}
+
+// groupDesiredChildren returns the Identities of the children of the given node, including
+// the managed ones, in a stable order, and the children grouped by Identity.
+func groupDesiredChildren(node *DesiredNode) ([]Identity, map[Identity][]*DesiredNode) {
+
+	var identities []Identity
+	grouped := map[Identity][]*DesiredNode{}
+
+	add := func(identity Identity) {
+		if _, ok := grouped[identity]; !ok {
+			identities = append(identities, identity)
+			grouped[identity] = nil
+		}
+	}
+
+	for _, child := range node.Children {
+		add(child.Object.Identity())
+		grouped[child.Object.Identity()] = append(grouped[child.Object.Identity()], child)
+	}
+
+	for _, identity := range node.ManagedIdentities {
+		add(identity)
+	}
+
+	return identities, grouped
+}
+
+// decodeLike decodes the given JSON objects into new instances of the type of the given
+// desired children, or of the type registered for the given Identity if there are none.
+func decodeLike(raw []json.RawMessage, identity Identity, desired []*DesiredNode) ([]Identifiable, *Error) {
+
+	factory := func() Identifiable { return DefaultIdentityRegistry.New(identity) }
+
+	if len(desired) > 0 {
+		objectType := reflect.TypeOf(desired[0].Object).Elem()
+		factory = func() Identifiable { return reflect.New(objectType).Interface().(Identifiable) }
+	}
+
+	objects := make([]Identifiable, len(raw))
+
+	for i, item := range raw {
+
+		object := factory()
+		if object == nil {
+			return nil, NewBambouError("Reconcile error", fmt.Sprintf("No registered identity named %s", identity.Name))
+		}
+
+		if err := json.Unmarshal(item, object); err != nil {
+			return nil, NewBambouError("JSON unmarshalling error", err.Error())
+		}
+
+		objects[i] = object
+	}
+
+	return objects, nil
+}
+
+// diffDesired returns the sorted names of the attributes set in the given desired
+// Identifiable that differ from the given existing JSON object.
+func diffDesired(desired Identifiable, existing json.RawMessage) ([]string, *Error) {
+
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return nil, NewBambouError("JSON error", err.Error())
+	}
+
+	desiredAttributes := map[string]interface{}{}
+	if err := json.Unmarshal(data, &desiredAttributes); err != nil {
+		return nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	existingAttributes := map[string]interface{}{}
+	if err := json.Unmarshal(existing, &existingAttributes); err != nil {
+		return nil, NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	var changes []string
+	for name, value := range desiredAttributes {
+
+		if name == "ID" || name == "parentID" || name == "parentType" {
+			continue
+		}
+
+		if !reflect.DeepEqual(value, existingAttributes[name]) {
+			changes = append(changes, name)
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes, nil
+}
+
+// sameIdentifiers returns true if the given identifiers are the ones of the given Identifiables.
+// The identifiers of the Identifiables matching existing objects are taken from the given matches.
+func sameIdentifiers(identifiers []string, objects []Identifiable, matches map[Identifiable]string) bool {
+
+	if len(identifiers) != len(objects) {
+		return false
+	}
+
+	expected := map[string]bool{}
+	for _, identifier := range identifiers {
+		expected[identifier] = true
+	}
+
+	for _, object := range objects {
+
+		identifier := object.Identifier()
+		if matched, ok := matches[object]; ok {
+			identifier = matched
+		}
+
+		if !expected[identifier] {
+			return false
+		}
+	}
+
+	return true
+}
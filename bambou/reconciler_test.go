@@ -0,0 +1,214 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// thingIdentity is the Identity of a thing.
+var thingIdentity = Identity{Name: "thing", Category: "things"}
+
+// thing is an Identifiable with several attributes.
+type thing struct {
+	ID          string `json:"ID,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func newThing(name string, description string) *thing {
+	return &thing{Name: name, Description: description}
+}
+
+func (o *thing) Identity() Identity      { return thingIdentity }
+func (o *thing) Identifier() string      { return o.ID }
+func (o *thing) SetIdentifier(ID string) { o.ID = ID }
+
+// planStrings returns the string representations of the actions of the given plan.
+func planStrings(plan ReconcilePlan) []string {
+
+	var actions []string
+	for _, action := range plan {
+		actions = append(actions, action.String())
+	}
+
+	return actions
+}
+
+func TestReconciler_Plan(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer with existing objects and a desired state", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		a := &thing{ID: "a", Name: "a", Description: "old"}
+		m.CreateChild(r, a)
+		m.CreateChild(r, &thing{ID: "b", Name: "b"})
+		m.CreateChild(r, &thing{ID: "stale", Name: "stale"})
+		m.CreateChild(a, &thing{ID: "a1", Name: "a1"})
+		m.CreateChild(r, NewFakeObject("f1"))
+		m.CreateChild(r, NewFakeObject("f2"))
+		m.AssignChildren(a, []Identifiable{NewFakeObject("f2")}, FakeIdentity)
+
+		desiredA := newThing("a", "new")
+		desiredC := newThing("c", "")
+		desired := &DesiredNode{
+			Object: r,
+			Children: []*DesiredNode{
+				{
+					Object: desiredA,
+					Children: []*DesiredNode{
+						{Object: newThing("a1", "")},
+						{Object: newThing("a2", "")},
+					},
+					Assignments: []*DesiredAssignment{
+						{Identity: FakeIdentity, Objects: []Identifiable{NewFakeObject("f1")}},
+					},
+				},
+				{Object: newThing("b", "")},
+				{
+					Object:   desiredC,
+					Children: []*DesiredNode{{Object: newThing("c1", "")}},
+				},
+			},
+		}
+
+		reconciler := NewReconciler(m)
+		reconciler.Prune = true
+
+		Convey("When I plan the changes", func() {
+
+			plan, err := reconciler.Plan(desired)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the plan should contain the actions in dependency order", func() {
+				So(planStrings(plan), ShouldResemble, []string{
+					`update thing "a" (description)`,
+					`create thing "a2"`,
+					`create thing "c"`,
+					`create thing "c1"`,
+					`assign 1 fakes to thing "a"`,
+					`delete thing "stale"`,
+				})
+				So(plan[1].Object, ShouldEqual, desired.Children[0].Children[1].Object)
+				So(plan[2].Object, ShouldEqual, desiredC)
+			})
+
+			Convey("Then the desired objects should not be modified", func() {
+				So(desiredA.ID, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I plan the changes without pruning", func() {
+
+			reconciler.Prune = false
+			plan, _ := reconciler.Plan(desired)
+
+			Convey("Then the plan should not contain deletions", func() {
+				for _, action := range plan {
+					So(action.Type, ShouldNotEqual, ReconcileActionDelete)
+				}
+			})
+		})
+
+		Convey("When I apply the changes", func() {
+
+			plan, err := reconciler.Apply(desired)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(len(plan), ShouldEqual, 6)
+			})
+
+			Convey("Then the existing object should be updated", func() {
+				o := &thing{ID: "a"}
+				m.FetchEntity(o)
+				So(o.Description, ShouldEqual, "new")
+				So(desiredA.ID, ShouldEqual, "a")
+			})
+
+			Convey("Then the new objects should be created", func() {
+				var l []*thing
+				m.FetchChildren(desiredC, thingIdentity, &l, nil)
+				So(len(l), ShouldEqual, 1)
+				So(l[0].Name, ShouldEqual, "c1")
+			})
+
+			Convey("Then the stale object should be deleted", func() {
+				So(m.FetchEntity(&thing{ID: "stale"}), ShouldNotBeNil)
+			})
+
+			Convey("Then the assignment should be updated", func() {
+				var l FakeObjectsList
+				m.FetchChildren(a, FakeIdentity, &l, nil)
+				So(len(l), ShouldEqual, 1)
+				So(l[0].ID, ShouldEqual, "f1")
+			})
+
+			Convey("When I plan the same desired state again", func() {
+
+				plan, err := reconciler.Plan(desired)
+
+				Convey("Then the plan should be empty", func() {
+					So(err, ShouldBeNil)
+					So(len(plan), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I apply changes failing at the end with rollback", func() {
+
+			reconciler.Rollback = true
+			desired.Children = append(desired.Children, &DesiredNode{Object: &thing{ID: "b", Name: "conflict"}})
+
+			_, err := reconciler.Apply(desired)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the applied changes should be cancelled", func() {
+				o := &thing{ID: "a"}
+				m.FetchEntity(o)
+				So(o.Description, ShouldEqual, "old")
+				So(m.FetchEntity(&thing{ID: desiredC.ID}), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I plan a desired state without root object", func() {
+
+			_, err := reconciler.Plan(&DesiredNode{})
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -25,14 +25,19 @@ package bambou
 
 import (
 	"bytes"
+	"container/list"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -62,6 +67,12 @@ type Storer interface {
 	NextEvent(NotificationsChannel, string) *Error
 }
 
+// Reauthenticator is the interface that can be implemented by a Storer able to
+// renew its authentication while it is used, without resetting its state.
+type Reauthenticator interface {
+	Reauthenticate() *Error
+}
+
 // Session represents a user session. It provides the entire
 // communication layer with the backend. It must implement the Operationable interface.
 // A session can be authenticated via 1) TLS certificates or 2) user + password (different API endpoints)
@@ -72,7 +83,65 @@ type Session struct {
 	Password     string
 	Organization string
 	URL          string
-	client       *http.Client
+
+	// ConditionalRequests enables the conditional fetches. The ETag and Last-Modified
+	// headers returned by the server are remembered for each URL with the body of the
+	// response, and sent back as If-None-Match and If-Modified-Since headers. When the
+	// server answers that the entity or the children have not been modified, the destination
+	// is filled again from the remembered body rather than left untouched, so it can be a new value.
+	// When the server does not return these headers, an entity is left untouched if its
+	// lastUpdatedDate has not changed. The root object is never fetched conditionally.
+	ConditionalRequests bool
+
+	// ValidatorsSize is the maximum number of URLs whose validators are remembered for
+	// ConditionalRequests and OptimisticConcurrency. The least recently used ones are
+	// forgotten first. If zero, the validators of 1000 URLs are remembered.
+	ValidatorsSize int
+
+	// CoalesceRequests enables the coalescing of the identical fetches. While a
+	// fetch is sent to the server, the identical ones wait for its response
+	// instead of sending another request.
+	CoalesceRequests bool
+
+	// TrackChanges enables the change tracking. A snapshot of each instance of an Identifiable
+	// is taken when it is fetched, listed by FetchChildren, created or saved, so Changes can
+	// list the attributes modified since then and SaveChanges can only send them.
+	TrackChanges bool
+
+	// SnapshotsSize is the maximum number of snapshots kept for TrackChanges. The snapshots
+	// of the least recently used instances are forgotten first. If zero, 1000 snapshots are kept.
+	SnapshotsSize int
+
+	// OptimisticConcurrency enables the verification of the version of an Identifiable
+	// before saving it. If the server returned an ETag when the Identifiable was fetched,
+	// the current ETag must be the same and it is sent in an If-Match header. Otherwise,
+	// the lastUpdatedDate of the Identifiable must be the same as on the server. If the
+	// versions differ, the returned Error has the code 409 and describes the Conflict.
+	// Without ETag, the version is verified by a request sent just before the update,
+	// so a change saved by someone else between both requests is still overwritten.
+	OptimisticConcurrency bool
+
+	snapshots      map[Identifiable]*list.Element
+	snapshotsLRU   list.List
+	snapshotsLock  sync.Mutex
+	flights        flightGroup
+	client         *http.Client
+	validators     map[string]*list.Element
+	validatorsLRU  list.List
+	validatorsLock sync.Mutex
+	authLock       sync.RWMutex // protects the API key of the root object
+}
+
+// defaultValidatorsSize is the number of URLs whose validators are remembered when no ValidatorsSize is given.
+const defaultValidatorsSize = 1000
+
+// validator represents the validators returned by the server for a URL,
+// with the response they validate if ConditionalRequests is enabled.
+type validator struct {
+	key          string
+	etag         string
+	lastModified string
+	response     *flightResponse
 }
 
 // NewSession returns a new *Session
@@ -124,6 +193,19 @@ func (s *Session) SetInsecureSkipVerify(skip bool) *Error {
 	return nil
 }
 
+// Transport returns the http.RoundTripper used to communicate with the server.
+func (s *Session) Transport() http.RoundTripper {
+
+	return s.client.Transport
+}
+
+// SetTransport sets the http.RoundTripper used to communicate with the server.
+// It can be used to wrap the default one, for instance with a FaultTransport.
+func (s *Session) SetTransport(transport http.RoundTripper) {
+
+	s.client.Transport = transport
+}
+
 // Used for user & password based authentication
 func (s *Session) makeAuthorizationHeaders() (string, *Error) {
 
@@ -135,7 +217,10 @@ func (s *Session) makeAuthorizationHeaders() (string, *Error) {
 		return "", NewBambouError("Invalid Credentials", "No root user set")
 	}
 
+	s.authLock.RLock()
 	key := s.root.APIKey()
+	s.authLock.RUnlock()
+
 	if s.Password == "" && key == "" {
 		return "", NewBambouError("Invalid Credentials", "No password or authentication token given")
 	}
@@ -149,7 +234,8 @@ func (s *Session) makeAuthorizationHeaders() (string, *Error) {
 
 func (s *Session) prepareHeaders(request *http.Request, info *FetchingInfo) *Error {
 
-	if s.Certificate == nil { // We're using user & password based authentication
+	// We're using user & password based authentication, unless the request is already authorized
+	if s.Certificate == nil && request.Header.Get("Authorization") == "" {
 
 		authString, err := s.makeAuthorizationHeaders()
 		if err != nil {
@@ -230,8 +316,34 @@ func (s *Session) send(request *http.Request, info *FetchingInfo) (*http.Respons
 		s.readHeaders(response, info)
 		return response, nil
 
+	case http.StatusNotModified:
+		return response, nil
+
+	case http.StatusBadRequest:
+		defer response.Body.Close()
+
+		body, _ := ioutil.ReadAll(response.Body)
+		log.Debugf("Response Body: %s", string(body))
+
+		// Keep the description of the server, if there is one
+		berr := NewBambouError("HTTP error", response.Status)
+
+		var vsdresp VsdErrorList
+		if err := json.Unmarshal(body, &vsdresp); err == nil && len(vsdresp.VsdErrors) > 0 && len(vsdresp.VsdErrors[0].Descriptions) > 0 {
+			berr = NewBambouError(vsdresp.VsdErrors[0].Descriptions[0].Title, vsdresp.VsdErrors[0].Descriptions[0].Description)
+		}
+		berr.Code = response.StatusCode
+
+		return nil, berr
+
 	case http.StatusMultipleChoices:
 		defer response.Body.Close()
+		if request.URL.Query().Get("responseChoice") != "" {
+			berr := NewBambouError("HTTP error", response.Status)
+			berr.Code = response.StatusCode
+
+			return nil, berr
+		}
 		newURL := request.URL.String() + "?responseChoice=1"
 		request.URL, _ = url.Parse(newURL)
 		return s.send(request, info)
@@ -247,16 +359,68 @@ func (s *Session) send(request *http.Request, info *FetchingInfo) (*http.Respons
 			return nil, NewBambouError("JSON unmarshalling error", err.Error())
 		}
 		// Check if there is an _actual_ VSD response -- we may get a bogus 40x from e.g. tests
+		var berr *Error
 		if len(vsdresp.VsdErrors) == 0 {
-			return nil, NewBambouError("Non-VSD server HTTP error", response.Status)
+			berr = NewBambouError("Non-VSD server HTTP error", response.Status)
 		} else { // Valid VSD response
-			return nil, NewBambouError(vsdresp.VsdErrors[0].Descriptions[0].Title, vsdresp.VsdErrors[0].Descriptions[0].Description)
+			berr = NewBambouError(vsdresp.VsdErrors[0].Descriptions[0].Title, vsdresp.VsdErrors[0].Descriptions[0].Description)
 		}
+		berr.Code = response.StatusCode
+
+		return nil, berr
 
 	default:
 		defer response.Body.Close()
-		return nil, NewBambouError("HTTP error", response.Status)
+		berr := NewBambouError("HTTP error", response.Status)
+		berr.Code = response.StatusCode
+
+		return nil, berr
+	}
+}
+
+// fetch sends the given request and reads the body of the response.
+// If CoalesceRequests is enabled, the identical requests sent concurrently
+// share the same response.
+func (s *Session) fetch(request *http.Request, info *FetchingInfo) (*flightResponse, *Error) {
+
+	send := func() (*flightResponse, *Error) {
+		return s.fetchUncoalesced(request, info)
+	}
+
+	if !s.CoalesceRequests {
+		return send()
+	}
+
+	key := request.Method + " " + childrenValidatorKey(request.URL.String(), info) + "|" + request.Header.Get("If-None-Match") + "|" + request.Header.Get("If-Modified-Since")
+
+	response, berr, shared := s.flights.do(key, send)
+
+	if shared && berr == nil && response.statusCode != http.StatusNotModified {
+		s.readHeaders(&http.Response{Header: response.header}, info)
 	}
+
+	return response, berr
+}
+
+// fetchUncoalesced sends the given request and reads the body of the response,
+// never sharing the response with other requests.
+func (s *Session) fetchUncoalesced(request *http.Request, info *FetchingInfo) (*flightResponse, *Error) {
+
+	response, berr := s.send(request, info)
+	if berr != nil {
+		return nil, berr
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	log.Debugf("Response Body: %s", string(body))
+
+	return &flightResponse{
+		statusCode:    response.StatusCode,
+		header:        response.Header,
+		contentLength: response.ContentLength,
+		body:          body,
+	}, nil
 }
 
 func (s *Session) getGeneralURL(o Identifiable) string {
@@ -312,14 +476,88 @@ func (s *Session) Start() *Error {
 	return nil
 }
 
+// Reauthenticate authenticates the session again with its password and updates the
+// API key of the root object. Unlike Reset and Start, it does not clear the state of
+// the session, so it can be used while other requests are sent.
+func (s *Session) Reauthenticate() *Error {
+
+	if s.Certificate != nil {
+		return nil
+	}
+
+	if s.Username == "" || s.Password == "" {
+		return NewBambouError("Invalid Credentials", "No username or password given")
+	}
+
+	rootType := reflect.TypeOf(s.root)
+	if rootType == nil || rootType.Kind() != reflect.Ptr {
+		return NewBambouError("Invalid Credentials", "The root object must be a pointer to be authenticated again")
+	}
+
+	url, berr := s.getPersonalURL(s.root)
+	if berr != nil {
+		return berr
+	}
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return NewBambouError("HTTP transaction error", err.Error())
+	}
+
+	request.Header.Set("Authorization", "XREST "+base64.StdEncoding.EncodeToString([]byte(s.Username+":"+s.Password)))
+	request.Header.Set("X-Nuage-Organization", s.Organization)
+
+	response, berr := s.send(request, nil)
+	if berr != nil {
+		return berr
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+
+	root, ok := reflect.New(rootType.Elem()).Interface().(Rootable)
+	if !ok {
+		return NewBambouError("Invalid Credentials", "The root object must be a pointer to be authenticated again")
+	}
+
+	dest := IdentifiablesList{root}
+	if err := json.Unmarshal(body, &dest); err != nil {
+		return NewBambouError("JSON Unmarshaling error", err.Error())
+	}
+
+	s.authLock.Lock()
+	s.root.SetAPIKey(root.APIKey())
+	s.authLock.Unlock()
+
+	return nil
+}
+
 // Reset resets the session.
 func (s *Session) Reset() {
 
+	s.authLock.Lock()
 	s.root.SetAPIKey("")
+	s.authLock.Unlock()
+
+	s.clearValidators()
+	s.clearSnapshots()
 
 	currentSession = nil
 }
 
+// lockAPIKey locks the API key if the given Identifiable is the root object,
+// so it can be decoded while requests are sent. It returns the function unlocking it.
+func (s *Session) lockAPIKey(object Identifiable) func() {
+
+	if _, ok := object.(Rootable); !ok {
+		return func() {}
+	}
+
+	s.authLock.Lock()
+
+	return s.authLock.Unlock
+}
+
 // FetchEntity fetchs the given Identifiable from the server.
 func (s *Session) FetchEntity(object Identifiable) *Error {
 
@@ -333,20 +571,40 @@ func (s *Session) FetchEntity(object Identifiable) *Error {
 		return NewBambouError("HTTP transaction error", err.Error())
 	}
 
-	response, berr := s.send(request, nil)
+	_, isRoot := object.(Rootable)
+	conditional := s.ConditionalRequests && !isRoot
+
+	var response *flightResponse
+	if conditional {
+		response, berr = s.fetchConditional(request, url, nil)
+	} else {
+		response, berr = s.fetch(request, nil)
+	}
+
 	if berr != nil {
 		return berr
 	}
-	defer response.Body.Close()
 
-	body, _ := ioutil.ReadAll(response.Body)
-	log.Debugf("Response Body: %s", string(body))
+	hasValidators := false
+	if conditional || s.OptimisticConcurrency {
+		hasValidators = s.saveValidators(url, response)
+	}
 
+	if conditional && !hasValidators && isUnchanged(object, response.body) {
+		return nil
+	}
+
+	unlock := s.lockAPIKey(object)
 	arr := IdentifiablesList{object} // trick for weird api..
-	if err := json.Unmarshal(body, &arr); err != nil {
+	err = json.Unmarshal(response.body, &arr)
+	unlock()
+
+	if err != nil {
 		return NewBambouError("JSON unmarshalling error", err.Error())
 	}
 
+	s.takeSnapshot(object)
+
 	return nil
 }
 
@@ -363,14 +621,32 @@ func (s *Session) SaveEntity(object Identifiable) *Error {
 		return NewBambouError("JSON error", err.Error())
 	}
 
-	url = url + "?responseChoice=1"
-	request, err := http.NewRequest("PUT", url, buffer)
+	return s.put(object, url, buffer)
+}
+
+// put sends the given body to the given URL of the given Identifiable
+// and updates the Identifiable from the response.
+func (s *Session) put(object Identifiable, url string, buffer *bytes.Buffer) *Error {
+
+	etag, berr := s.checkVersion(object, url)
+	if berr != nil {
+		return berr
+	}
+
+	request, err := http.NewRequest("PUT", url+"?responseChoice=1", buffer)
 	if err != nil {
 		return NewBambouError("HTTP transaction error", err.Error())
 	}
 
+	if etag != "" {
+		request.Header.Set("If-Match", etag)
+	}
+
 	response, berr := s.send(request, nil)
 	if berr != nil {
+		if berr.Code == http.StatusPreconditionFailed {
+			return s.newConflictError(object, url)
+		}
 		return berr
 	}
 	defer response.Body.Close()
@@ -378,13 +654,28 @@ func (s *Session) SaveEntity(object Identifiable) *Error {
 	body, _ := ioutil.ReadAll(response.Body)
 	log.Debugf("Response Body: %s", string(body))
 
+	if s.OptimisticConcurrency {
+		s.saveValidators(url, &flightResponse{
+			statusCode:    response.StatusCode,
+			header:        response.Header,
+			contentLength: int64(len(body)),
+			body:          body,
+		})
+	}
+
 	dest := IdentifiablesList{object}
 	if len(body) > 0 {
-		if err := json.Unmarshal(body, &dest); err != nil {
+		unlock := s.lockAPIKey(object)
+		err := json.Unmarshal(body, &dest)
+		unlock()
+
+		if err != nil {
 			return NewBambouError("JSON Unmarshaling error", err.Error())
 		}
 	}
 
+	s.takeSnapshot(object)
+
 	return nil
 }
 
@@ -409,6 +700,9 @@ func (s *Session) DeleteEntity(object Identifiable) *Error {
 	}
 	defer response.Body.Close()
 
+	s.deleteValidators(strings.TrimSuffix(url, "?responseChoice=1"))
+	s.forgetSnapshot(object)
+
 	return nil
 }
 
@@ -425,23 +719,33 @@ func (s *Session) FetchChildren(parent Identifiable, identity Identity, dest int
 		return NewBambouError("HTTP transaction error", err.Error())
 	}
 
-	response, berr := s.send(request, info)
+	key := childrenValidatorKey(url, info)
+
+	var response *flightResponse
+	if s.ConditionalRequests {
+		response, berr = s.fetchConditional(request, key, info)
+	} else {
+		response, berr = s.fetch(request, info)
+	}
+
 	if berr != nil {
 		return berr
 	}
-	defer response.Body.Close()
 
-	body, _ := ioutil.ReadAll(response.Body)
-	log.Debugf("Response Body: %s", string(body))
+	if s.ConditionalRequests {
+		s.saveValidators(key, response)
+	}
 
-	if response.StatusCode == http.StatusNoContent || response.ContentLength == 0 {
+	if response.statusCode == http.StatusNoContent || response.contentLength == 0 {
 		return nil
 	}
 
-	if err := json.Unmarshal(body, &dest); err != nil {
+	if err := json.Unmarshal(response.body, &dest); err != nil {
 		return NewBambouError("HTTP Unmarshaling error", err.Error())
 	}
 
+	s.takeSnapshots(dest)
+
 	return nil
 }
 
@@ -477,10 +781,13 @@ func (s *Session) CreateChild(parent Identifiable, child Identifiable) *Error {
 		return NewBambouError("JSON Unmarshaling error", err.Error())
 	}
 
+	s.takeSnapshot(child)
+
 	return nil
 }
 
 // AssignChildren assigns the list of given child Identifiables to the given Identifiable parent in the server.
+// Without children, an empty list is sent rather than null, so the last children can be unassigned.
 func (s *Session) AssignChildren(parent Identifiable, children []Identifiable, identity Identity) *Error {
 
 	url, berr := s.getURLForChildrenIdentity(parent, identity)
@@ -488,7 +795,7 @@ func (s *Session) AssignChildren(parent Identifiable, children []Identifiable, i
 		return berr
 	}
 
-	var ids []string
+	ids := []string{}
 	for _, c := range children {
 
 		if i := c.Identifier(); i != "" {
@@ -516,9 +823,16 @@ func (s *Session) AssignChildren(parent Identifiable, children []Identifiable, i
 }
 
 // NextEvent will return the next notification from the backend as it occurs and will
-// send it to the correct channel.
+// send it to the correct channel. A notification without event is also sent if its
+// UUID is not the given lastEventID, so the caller can use it for the next call.
 func (s *Session) NextEvent(channel NotificationsChannel, lastEventID string) *Error {
 
+	return s.NextEventContext(context.Background(), channel, lastEventID)
+}
+
+// NextEventContext is like NextEvent, but the request is canceled when the given context is done.
+func (s *Session) NextEventContext(ctx context.Context, channel NotificationsChannel, lastEventID string) *Error {
+
 	currentURL := s.URL + "/events"
 	if lastEventID != "" {
 		currentURL += "?uuid=" + lastEventID
@@ -528,6 +842,7 @@ func (s *Session) NextEvent(channel NotificationsChannel, lastEventID string) *E
 	if err != nil {
 		return NewBambouError("HTTP transaction error", err.Error())
 	}
+	request = request.WithContext(ctx)
 
 	response, berr := s.send(request, nil)
 	if berr != nil {
@@ -540,9 +855,163 @@ func (s *Session) NextEvent(channel NotificationsChannel, lastEventID string) *E
 		return NewBambouError("JSON error", err.Error())
 	}
 
-	if len(notification.Events) > 0 {
+	if len(notification.Events) > 0 || notification.UUID != lastEventID {
 		channel <- notification
 	}
 
 	return nil
 }
+
+// fetchConditional fetches the given request with the conditional headers of the validators
+// saved with the given key. When the server answers that the resource has not been modified,
+// the response saved with the validators is returned instead.
+func (s *Session) fetchConditional(request *http.Request, key string, info *FetchingInfo) (*flightResponse, *Error) {
+
+	// The validators saved without response cannot be used to fill the destination
+	v, ok := s.validator(key)
+	if ok && v.response == nil {
+		ok = false
+	}
+
+	if ok {
+		if v.etag != "" {
+			request.Header.Set("If-None-Match", v.etag)
+		}
+
+		if v.lastModified != "" {
+			request.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	response, berr := s.fetch(request, info)
+	if berr != nil {
+		return nil, berr
+	}
+
+	if response.statusCode != http.StatusNotModified {
+		return response, nil
+	}
+
+	if !ok {
+		berr := NewBambouError("HTTP error", "The server answered not modified to an unconditional request")
+		berr.Code = http.StatusNotModified
+		return nil, berr
+	}
+
+	s.readHeaders(&http.Response{Header: v.response.header}, info)
+
+	return v.response, nil
+}
+
+// saveValidators saves the validators of the given response with the given key.
+// The response is only kept if ConditionalRequests is enabled.
+// It returns false if the response does not contain any validator.
+func (s *Session) saveValidators(key string, response *flightResponse) bool {
+
+	v := &validator{
+		key:          key,
+		etag:         response.header.Get("ETag"),
+		lastModified: response.header.Get("Last-Modified"),
+	}
+
+	if s.ConditionalRequests {
+		v.response = response
+	}
+
+	s.validatorsLock.Lock()
+	defer s.validatorsLock.Unlock()
+
+	if element, ok := s.validators[key]; ok {
+		s.validatorsLRU.Remove(element)
+		delete(s.validators, key)
+	}
+
+	if v.etag == "" && v.lastModified == "" {
+		return false
+	}
+
+	if s.validators == nil {
+		s.validators = map[string]*list.Element{}
+	}
+
+	s.validators[key] = s.validatorsLRU.PushFront(v)
+
+	size := s.ValidatorsSize
+	if size <= 0 {
+		size = defaultValidatorsSize
+	}
+
+	for s.validatorsLRU.Len() > size {
+		oldest := s.validatorsLRU.Back()
+		s.validatorsLRU.Remove(oldest)
+		delete(s.validators, oldest.Value.(*validator).key)
+	}
+
+	return true
+}
+
+// validator returns the validators saved with the given key.
+func (s *Session) validator(key string) (*validator, bool) {
+
+	s.validatorsLock.Lock()
+	defer s.validatorsLock.Unlock()
+
+	element, ok := s.validators[key]
+	if !ok {
+		return nil, false
+	}
+
+	s.validatorsLRU.MoveToFront(element)
+
+	return element.Value.(*validator), true
+}
+
+// deleteValidators deletes the validators of the given URL.
+func (s *Session) deleteValidators(url string) {
+
+	s.validatorsLock.Lock()
+	defer s.validatorsLock.Unlock()
+
+	if element, ok := s.validators[url]; ok {
+		s.validatorsLRU.Remove(element)
+		delete(s.validators, url)
+	}
+}
+
+// clearValidators deletes all the validators.
+func (s *Session) clearValidators() {
+
+	s.validatorsLock.Lock()
+	defer s.validatorsLock.Unlock()
+
+	s.validators = nil
+	s.validatorsLRU.Init()
+}
+
+// childrenValidatorKey returns the key of the validators of the children at the given URL
+// fetched with the given FetchingInfo.
+func childrenValidatorKey(url string, info *FetchingInfo) string {
+
+	if info == nil {
+		return url
+	}
+
+	return fmt.Sprintf("%s|%d|%d|%s|%s|%s", url, info.Page, info.PageSize, info.Filter, info.OrderBy, strings.Join(info.GroupBy, ","))
+}
+
+// isUnchanged returns true if the entity of the given response body has
+// the same lastUpdatedDate as the given Identifiable.
+func isUnchanged(object Identifiable, body []byte) bool {
+
+	var received []map[string]interface{}
+	if err := json.Unmarshal(body, &received); err != nil || len(received) == 0 {
+		return false
+	}
+
+	date, ok := lastUpdatedDate(object)
+	if !ok {
+		return false
+	}
+
+	return date == fmt.Sprint(received[0]["lastUpdatedDate"])
+}
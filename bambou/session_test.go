@@ -27,8 +27,11 @@ import (
 	"fmt"
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -967,6 +970,25 @@ func TestSession_AssignChildren(t *testing.T) {
 			})
 		})
 
+		Convey("When I assign no objects", func() {
+
+			var body string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := ioutil.ReadAll(r.Body)
+				body = string(data)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			session := NewSession("username", "password", "organization", ts.URL, r)
+			err := session.AssignChildren(NewFakeObject("xxx"), nil, FakeIdentity)
+
+			Convey("Then an empty list should be sent", func() {
+				So(err, ShouldBeNil)
+				So(body, ShouldEqual, "[]\n")
+			})
+		})
+
 		Convey("When I assign objects to a parent that has no ID", func() {
 
 			session := NewSession("username", "password", "organization", "http://fake.com", nil)
@@ -1304,6 +1326,40 @@ func TestSession_Send(t *testing.T) {
 				So(string(err.Title), ShouldEqual, "Non-VSD server HTTP error")
 				So(err.Description, ShouldEqual, "409 Conflict")
 			})
+
+			Convey("Then the error Code should be StatusConflict", func() {
+				So(err.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+
+		Convey("When I send a request that returns 400", func() {
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				if r.URL.Path == "/vsd" {
+					fmt.Fprint(w, `{"errors": [{"property": "", "descriptions": [{"title": "Unknown event", "description": "No event with this UUID"}]}]}`)
+				}
+			}))
+			defer ts.Close()
+			session := NewSession("username", "password", "organization", ts.URL, r)
+
+			req1, _ := http.NewRequest("GET", ts.URL+"/vsd", nil)
+			_, err1 := session.send(req1, nil)
+
+			req2, _ := http.NewRequest("GET", ts.URL, nil)
+			_, err2 := session.send(req2, nil)
+
+			Convey("Then the error of the server should be returned", func() {
+				So(err1.Title, ShouldEqual, "Unknown event")
+				So(err1.Description, ShouldEqual, "No event with this UUID")
+				So(err1.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Then the status should be returned without error from the server", func() {
+				So(err2.Title, ShouldEqual, "HTTP error")
+				So(err2.Description, ShouldEqual, "400 Bad Request")
+				So(err2.Code, ShouldEqual, http.StatusBadRequest)
+			})
 		})
 
 		Convey("When I send a request that returns any other code", func() {
@@ -1329,6 +1385,383 @@ func TestSession_Send(t *testing.T) {
 				So(err.Title, ShouldEqual, "HTTP error")
 				So(err.Description, ShouldEqual, "500 Internal Server Error")
 			})
+
+			Convey("Then the error Code should be StatusInternalServerError", func() {
+				So(err.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
+
+// datedFakeObject is a FakeObject with a lastUpdatedDate.
+type datedFakeObject struct {
+	FakeObject
+	LastUpdatedDate string `json:"lastUpdatedDate,omitempty"`
+}
+
+func TestSession_ConditionalRequests(t *testing.T) {
+
+	Convey("Given I have a server returning an ETag and a session with conditional requests", t, func() {
+
+		var conditions []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conditions = append(conditions, r.Header.Get("If-None-Match")+r.Header.Get("If-Modified-Since"))
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello"}]`)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.ConditionalRequests = true
+
+		Convey("When I fetch an entity twice", func() {
+
+			o := NewFakeObject("xxx")
+			err1 := session.FetchEntity(o)
+			o.Name = "local"
+			err2 := session.FetchEntity(o)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the second request should be conditional", func() {
+				So(conditions, ShouldResemble, []string{"", `"v1"`})
+			})
+
+			Convey("Then the entity should be filled from the previous response", func() {
+				So(o.Name, ShouldEqual, "hello")
+			})
+
+			Convey("When I fetch it again into a new object", func() {
+
+				o2 := NewFakeObject("xxx")
+				err := session.FetchEntity(o2)
+
+				Convey("Then the new object should be filled from the previous response", func() {
+					So(err, ShouldBeNil)
+					So(o2.Name, ShouldEqual, "hello")
+				})
+			})
+
+			Convey("When I delete it and fetch it again", func() {
+
+				session.DeleteEntity(o)
+				session.FetchEntity(o)
+
+				Convey("Then the last request should not be conditional", func() {
+					So(conditions[len(conditions)-1], ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When I fetch children twice", func() {
+
+			var l1, l2 FakeObjectsList
+			session.FetchChildren(NewFakeObject("yyy"), FakeIdentity, &l1, nil)
+			session.FetchChildren(NewFakeObject("yyy"), FakeIdentity, &l2, nil)
+
+			Convey("Then the second request should be conditional", func() {
+				So(conditions, ShouldResemble, []string{"", `"v1"`})
+			})
+
+			Convey("Then both lists should be filled", func() {
+				So(len(l1), ShouldEqual, 1)
+				So(len(l2), ShouldEqual, 1)
+				So(l2[0].Name, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When I fetch all the identifiers of the children twice", func() {
+
+			ids1, err1 := fetchAllIdentifiers(session, NewFakeObject("yyy"), FakeIdentity)
+			ids2, err2 := fetchAllIdentifiers(session, NewFakeObject("yyy"), FakeIdentity)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the second request should be conditional", func() {
+				So(conditions, ShouldResemble, []string{"", `"v1"`})
+			})
+
+			Convey("Then both results should contain the children", func() {
+				So(ids1, ShouldResemble, []string{"xxx"})
+				So(ids2, ShouldResemble, []string{"xxx"})
+			})
+		})
+
+		Convey("When I fetch the root object twice", func() {
+
+			session.FetchEntity(session.Root())
+			session.FetchEntity(session.Root())
+
+			Convey("Then no request should be conditional", func() {
+				So(conditions, ShouldResemble, []string{"", ""})
+			})
+		})
+
+		Convey("When I disable conditional requests and fetch an entity twice", func() {
+
+			session.ConditionalRequests = false
+			session.FetchEntity(NewFakeObject("xxx"))
+			session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then no request should be conditional", func() {
+				So(conditions, ShouldResemble, []string{"", ""})
+			})
+		})
+
+		Convey("When I limit the validators to one URL and fetch two entities", func() {
+
+			session.ValidatorsSize = 1
+			session.FetchEntity(NewFakeObject("xxx"))
+			session.FetchEntity(NewFakeObject("yyy"))
+			session.FetchEntity(NewFakeObject("xxx"))
+
+			Convey("Then the validators of the first entity should have been forgotten", func() {
+				So(conditions, ShouldResemble, []string{"", "", ""})
+				So(len(session.validators), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I only enable optimistic concurrency and fetch an entity", func() {
+
+			session.ConditionalRequests = false
+			session.OptimisticConcurrency = true
+			session.FetchEntity(NewFakeObject("xxx"))
+
+			v, ok := session.validator(ts.URL + "/fakes/xxx")
+
+			Convey("Then the validators should be remembered without the response", func() {
+				So(ok, ShouldBeTrue)
+				So(v.etag, ShouldEqual, `"v1"`)
+				So(v.response, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a server without validators and a session with conditional requests", t, func() {
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello", "lastUpdatedDate": "1000"}]`)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.ConditionalRequests = true
+
+		Convey("When I fetch an entity with the same lastUpdatedDate", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx", Name: "local"}, LastUpdatedDate: "1000"}
+			session.FetchEntity(o)
+
+			Convey("Then the entity should be left untouched", func() {
+				So(o.Name, ShouldEqual, "local")
+			})
+		})
+
+		Convey("When I fetch an entity with another lastUpdatedDate", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx", Name: "local"}, LastUpdatedDate: "900"}
+			session.FetchEntity(o)
+
+			Convey("Then the entity should be updated", func() {
+				So(o.Name, ShouldEqual, "hello")
+				So(o.LastUpdatedDate, ShouldEqual, "1000")
+			})
+		})
+
+		Convey("When I fetch an entity without lastUpdatedDate", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx"}}
+			session.FetchEntity(o)
+
+			Convey("Then the entity should be updated", func() {
+				So(o.Name, ShouldEqual, "hello")
+			})
+		})
+	})
+}
+
+func TestSession_CoalesceRequests(t *testing.T) {
+
+	Convey("Given I have a slow server", t, func() {
+
+		var requests int32
+		release := make(chan struct{})
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			<-release
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Nuage-Count", "1")
+			fmt.Fprint(w, `[{"ID": "xxx", "name": "hello"}]`)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+
+		fetchConcurrently := func(fetch func() string) []string {
+
+			results := make(chan string, 5)
+			for i := 0; i < 5; i++ {
+				go func() { results <- fetch() }()
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+
+			var names []string
+			for i := 0; i < 5; i++ {
+				names = append(names, <-results)
+			}
+
+			return names
+		}
+
+		Convey("When I fetch the same entity concurrently with coalescing", func() {
+
+			session.CoalesceRequests = true
+			names := fetchConcurrently(func() string {
+				o := NewFakeObject("xxx")
+				session.FetchEntity(o)
+				return o.Name
+			})
+
+			Convey("Then only one request should have been sent", func() {
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+
+			Convey("Then all the objects should be fetched", func() {
+				So(names, ShouldResemble, []string{"hello", "hello", "hello", "hello", "hello"})
+			})
+		})
+
+		Convey("When I fetch the same children concurrently with coalescing", func() {
+
+			session.CoalesceRequests = true
+			names := fetchConcurrently(func() string {
+				var l FakeObjectsList
+				info := &FetchingInfo{Page: 0, PageSize: 10}
+				session.FetchChildren(NewFakeObject("yyy"), FakeIdentity, &l, info)
+				return fmt.Sprintf("%s %d", l[0].Name, info.TotalCount)
+			})
+
+			Convey("Then only one request should have been sent", func() {
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+
+			Convey("Then all the lists and fetching infos should be filled", func() {
+				So(names, ShouldResemble, []string{"hello 1", "hello 1", "hello 1", "hello 1", "hello 1"})
+			})
+		})
+
+		Convey("When I fetch the same entity concurrently without coalescing", func() {
+
+			fetchConcurrently(func() string {
+				o := NewFakeObject("xxx")
+				session.FetchEntity(o)
+				return o.Name
+			})
+
+			Convey("Then one request per fetch should have been sent", func() {
+				So(atomic.LoadInt32(&requests), ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+// valueRoot is a Rootable that is not a pointer.
+type valueRoot struct{}
+
+func (o valueRoot) Identity() Identity      { return FakeRootIdentity }
+func (o valueRoot) Identifier() string      { return "" }
+func (o valueRoot) SetIdentifier(ID string) {}
+func (o valueRoot) APIKey() string          { return "" }
+func (o valueRoot) SetAPIKey(key string)    {}
+
+func TestSession_Reauthenticate(t *testing.T) {
+
+	Convey("Given I have a server and a session with an expired API key", t, func() {
+
+		var authorization string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"ID": "root", "APIKey": "new-key"}]`)
+		}))
+		defer ts.Close()
+
+		r := NewFakeRootObject()
+		r.SetAPIKey("expired-key")
+		session := NewSession("username", "password", "organization", ts.URL, r)
+		session.TrackChanges = true
+		session.takeSnapshot(NewFakeObject("xxx"))
+
+		Convey("When I authenticate it again", func() {
+
+			err := session.Reauthenticate()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the password should have been used", func() {
+				So(authorization, ShouldEqual, "XREST dXNlcm5hbWU6cGFzc3dvcmQ=")
+			})
+
+			Convey("Then the root object should have the new API key", func() {
+				So(r.APIKey(), ShouldEqual, "new-key")
+			})
+
+			Convey("Then the state of the session should be kept", func() {
+				So(len(session.snapshots), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I authenticate it again while requests are sent and the root object is fetched", func() {
+
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(3)
+				go func() { defer wg.Done(); session.Reauthenticate() }()
+				go func() { defer wg.Done(); session.FetchEntity(r) }()
+				go func() { defer wg.Done(); session.FetchChildren(r, FakeIdentity, &[]*FakeObject{}, nil) }()
+			}
+			wg.Wait()
+
+			Convey("Then the root object should have the new API key", func() {
+				So(r.APIKey(), ShouldEqual, "new-key")
+			})
+		})
+
+		Convey("When I authenticate it again without password", func() {
+
+			session.Password = ""
+			err := session.Reauthenticate()
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(r.APIKey(), ShouldEqual, "expired-key")
+			})
+		})
+
+		Convey("When I authenticate again a session whose root object is not a pointer", func() {
+
+			err := NewSession("username", "password", "organization", ts.URL, valueRoot{}).Reauthenticate()
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
 		})
 	})
 }
@@ -0,0 +1,143 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package storertest
+
+import (
+	"encoding/json"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// RootIdentity is the Identity of the Root object.
+var RootIdentity = bambou.Identity{
+	Name:     "me",
+	Category: "me",
+}
+
+// ObjectIdentity is the Identity of the Object used by the conformance suite.
+var ObjectIdentity = bambou.Identity{
+	Name:     "object",
+	Category: "objects",
+}
+
+// Root is a bambou.Rootable that can be used to create the Storer to test.
+type Root struct {
+	ID    string `json:"ID,omitempty"`
+	Token string `json:"APIKey,omitempty"`
+}
+
+// NewRoot returns a new *Root.
+func NewRoot() *Root {
+
+	return &Root{}
+}
+
+// Identity returns the Identity of the Root.
+func (o *Root) Identity() bambou.Identity { return RootIdentity }
+
+// Identifier returns the unique identifier of the Root.
+func (o *Root) Identifier() string { return o.ID }
+
+// SetIdentifier sets the unique identifier of the Root.
+func (o *Root) SetIdentifier(ID string) { o.ID = ID }
+
+// APIKey returns the token of the Root.
+func (o *Root) APIKey() string { return o.Token }
+
+// SetAPIKey sets the token of the Root.
+func (o *Root) SetAPIKey(key string) { o.Token = key }
+
+// ObjectsList represents a list of *Object.
+type ObjectsList []*Object
+
+// Object is the bambou.Identifiable manipulated by the conformance suite.
+type Object struct {
+	ID         string `json:"ID,omitempty"`
+	ParentID   string `json:"parentID,omitempty"`
+	ParentType string `json:"parentType,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// NewObject returns a new *Object with the given identifier.
+func NewObject(ID string) *Object {
+
+	return &Object{ID: ID}
+}
+
+// Identity returns the Identity of the Object.
+func (o *Object) Identity() bambou.Identity { return ObjectIdentity }
+
+// Identifier returns the unique identifier of the Object.
+func (o *Object) Identifier() string { return o.ID }
+
+// SetIdentifier sets the unique identifier of the Object.
+func (o *Object) SetIdentifier(ID string) { o.ID = ID }
+
+// rawObject is a bambou.Identifiable of any Identity holding its
+// attributes as they are received.
+type rawObject struct {
+	identity bambou.Identity
+	data     map[string]interface{}
+}
+
+func newRawObject(identity bambou.Identity, ID string) *rawObject {
+
+	o := &rawObject{
+		identity: identity,
+		data:     map[string]interface{}{},
+	}
+
+	if ID != "" {
+		o.SetIdentifier(ID)
+	}
+
+	return o
+}
+
+func (o *rawObject) Identity() bambou.Identity { return o.identity }
+func (o *rawObject) SetIdentifier(ID string)   { o.data["ID"] = ID }
+
+func (o *rawObject) Identifier() string {
+
+	ID, _ := o.data["ID"].(string)
+	return ID
+}
+
+func (o *rawObject) MarshalJSON() ([]byte, error) {
+
+	return json.Marshal(o.data)
+}
+
+func (o *rawObject) UnmarshalJSON(data []byte) error {
+
+	return json.Unmarshal(data, &o.data)
+}
+
+// rawRoot is the bambou.Rootable used by the Server.
+type rawRoot struct {
+	*rawObject
+}
+
+func (o *rawRoot) APIKey() string       { return "" }
+func (o *rawRoot) SetAPIKey(key string) {}
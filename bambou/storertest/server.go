@@ -0,0 +1,264 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package storertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// Server is a fake ReST server implementing the part of the VSD API used by
+// a bambou.Session. All the objects are kept in a bambou.MemoryStorer.
+type Server struct {
+	*httptest.Server
+
+	// Storer is the bambou.MemoryStorer holding the objects of the Server.
+	Storer *bambou.MemoryStorer
+
+	root       *rawRoot
+	identities map[string]bambou.Identity
+}
+
+// NewServer starts and returns a new *Server serving the given root Identity
+// and the given identities. The caller should call Close when finished.
+func NewServer(rootIdentity bambou.Identity, identities ...bambou.Identity) *Server {
+
+	root := &rawRoot{newRawObject(rootIdentity, "")}
+
+	s := &Server{
+		Storer:     bambou.NewMemoryStorer(root),
+		root:       root,
+		identities: map[string]bambou.Identity{},
+	}
+	s.Storer.EventTimeout = time.Second
+
+	for _, identity := range identities {
+		s.identities[identity.Category] = identity
+	}
+
+	s.Server = httptest.NewServer(s)
+
+	return s
+}
+
+// ServeHTTP handles the given request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(segments) == 1 && segments[0] == s.root.identity.Name && r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, []interface{}{map[string]string{"ID": s.root.identity.Name, "APIKey": "token"}})
+		return
+	}
+
+	if len(segments) == 1 && segments[0] == "events" && r.Method == http.MethodGet {
+		s.serveEvents(w, r)
+		return
+	}
+
+	identity, ok := s.identities[segments[0]]
+	if !ok {
+		writeError(w, notFoundError(segments[0]))
+		return
+	}
+
+	switch len(segments) {
+
+	case 1:
+		s.serveChildren(w, r, s.root, identity)
+
+	case 2:
+		s.serveEntity(w, r, newRawObject(identity, segments[1]))
+
+	case 3:
+		childIdentity, ok := s.identities[segments[2]]
+		if !ok {
+			writeError(w, notFoundError(segments[2]))
+			return
+		}
+		s.serveChildren(w, r, newRawObject(identity, segments[1]), childIdentity)
+
+	default:
+		writeError(w, notFoundError(r.URL.Path))
+	}
+}
+
+func (s *Server) serveEntity(w http.ResponseWriter, r *http.Request, object *rawObject) {
+
+	switch r.Method {
+
+	case http.MethodGet:
+		if berr := s.Storer.FetchEntity(object); berr != nil {
+			writeError(w, berr)
+			return
+		}
+		writeJSON(w, http.StatusOK, []interface{}{object})
+
+	case http.MethodPut:
+		ID := object.Identifier()
+		if err := json.NewDecoder(r.Body).Decode(object); err != nil {
+			writeError(w, bambou.NewBambouError("JSON error", err.Error()))
+			return
+		}
+		object.SetIdentifier(ID)
+
+		if berr := s.Storer.SaveEntity(object); berr != nil {
+			writeError(w, berr)
+			return
+		}
+		writeJSON(w, http.StatusOK, []interface{}{object})
+
+	case http.MethodDelete:
+		if berr := s.Storer.DeleteEntity(object); berr != nil {
+			writeError(w, berr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveChildren(w http.ResponseWriter, r *http.Request, parent bambou.Identifiable, identity bambou.Identity) {
+
+	switch r.Method {
+
+	case http.MethodGet:
+		info := bambou.NewFetchingInfo()
+		if page, err := strconv.Atoi(r.Header.Get("X-Nuage-Page")); err == nil {
+			info.Page = page
+		}
+		if pageSize, err := strconv.Atoi(r.Header.Get("X-Nuage-PageSize")); err == nil {
+			info.PageSize = pageSize
+		}
+
+		var children []json.RawMessage
+		if berr := s.Storer.FetchChildren(parent, identity, &children, info); berr != nil {
+			writeError(w, berr)
+			return
+		}
+
+		w.Header().Set("X-Nuage-Page", strconv.Itoa(info.Page))
+		w.Header().Set("X-Nuage-PageSize", strconv.Itoa(info.PageSize))
+		w.Header().Set("X-Nuage-Count", strconv.Itoa(info.TotalCount))
+
+		if len(children) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, children)
+
+	case http.MethodPost:
+		child := newRawObject(identity, "")
+		if err := json.NewDecoder(r.Body).Decode(child); err != nil {
+			writeError(w, bambou.NewBambouError("JSON error", err.Error()))
+			return
+		}
+
+		if berr := s.Storer.CreateChild(parent, child); berr != nil {
+			writeError(w, berr)
+			return
+		}
+		writeJSON(w, http.StatusCreated, []interface{}{child})
+
+	case http.MethodPut:
+		var IDs []string
+		if err := json.NewDecoder(r.Body).Decode(&IDs); err != nil {
+			writeError(w, bambou.NewBambouError("JSON error", err.Error()))
+			return
+		}
+
+		children := make([]bambou.Identifiable, len(IDs))
+		for i, ID := range IDs {
+			children[i] = newRawObject(identity, ID)
+		}
+
+		if berr := s.Storer.AssignChildren(parent, children, identity); berr != nil {
+			writeError(w, berr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+
+	lastEventID := r.URL.Query().Get("uuid")
+
+	channel := make(bambou.NotificationsChannel, 1)
+	if berr := s.Storer.NextEvent(channel, lastEventID); berr != nil {
+		writeError(w, berr)
+		return
+	}
+
+	select {
+	case notification := <-channel:
+		writeJSON(w, http.StatusOK, notification)
+	default:
+		notification := bambou.NewNotification()
+		notification.UUID = lastEventID
+		writeJSON(w, http.StatusOK, notification)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, berr *bambou.Error) {
+
+	status := berr.Code
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, bambou.VsdErrorList{
+		VsdErrors: []bambou.VsdError{
+			{
+				Descriptions: []bambou.Error{*berr},
+			},
+		},
+	})
+}
+
+func notFoundError(name string) *bambou.Error {
+
+	berr := bambou.NewBambouError("Object not found", "Cannot find "+name)
+	berr.Code = http.StatusNotFound
+
+	return berr
+}
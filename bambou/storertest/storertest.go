@@ -0,0 +1,372 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+/*
+Package storertest provides a conformance suite for implementations of bambou.Storer.
+
+The suite verifies that a Storer behaves like a bambou.Session talking to the
+server: CRUD semantics, pagination, assignments, errors returned for missing
+objects and delivery of the events.
+
+	func TestMyStorer(t *testing.T) {
+		storertest.Run(t, func(t *testing.T) (bambou.Storer, func()) {
+			return NewMyStorer(storertest.NewRoot()), func() {}
+		})
+	}
+
+The Storer must accept objects of the ObjectIdentity under its root, and
+NextEvent called with an empty lastEventID must send, like the server, a
+notification carrying the identifier from which the following events are
+delivered.
+*/
+package storertest
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuagenetworks/go-bambou/bambou"
+)
+
+// EventTimeout is the maximum time the suite waits for an event.
+var EventTimeout = 5 * time.Second
+
+// Factory returns a new Storer holding no object, and a function releasing
+// the resources it uses. The returned Storer must not be started yet, and its
+// NextEvent must return after a while when there is no new event, like the
+// long polling of the server, so the suite does not leave goroutines blocked.
+type Factory func(t *testing.T) (bambou.Storer, func())
+
+// Run runs the conformance suite against the Storers returned by the given Factory.
+func Run(t *testing.T, factory Factory) {
+
+	tests := []struct {
+		name string
+		run  func(*testing.T, bambou.Storer)
+	}{
+		{"CRUD", testCRUD},
+		{"MissingObjects", testMissingObjects},
+		{"Pagination", testPagination},
+		{"Assignment", testAssignment},
+		{"Events", testEvents},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+
+			s, release := factory(t)
+			defer release()
+
+			if berr := s.Start(); berr != nil {
+				t.Fatalf("Start returned an error: %s", berr)
+			}
+			defer s.Reset()
+
+			test.run(t, s)
+		})
+	}
+}
+
+func testCRUD(t *testing.T, s bambou.Storer) {
+
+	o := &Object{Name: "hello"}
+	if berr := s.CreateChild(s.Root(), o); berr != nil {
+		t.Fatalf("CreateChild returned an error: %s", berr)
+	}
+	if o.ID == "" {
+		t.Fatalf("CreateChild did not set the identifier")
+	}
+
+	fetched := NewObject(o.ID)
+	if berr := s.FetchEntity(fetched); berr != nil {
+		t.Fatalf("FetchEntity returned an error: %s", berr)
+	}
+	if fetched.Name != "hello" {
+		t.Errorf("FetchEntity returned name %q, expected %q", fetched.Name, "hello")
+	}
+
+	o.Name = "world"
+	if berr := s.SaveEntity(o); berr != nil {
+		t.Fatalf("SaveEntity returned an error: %s", berr)
+	}
+
+	fetched = NewObject(o.ID)
+	if berr := s.FetchEntity(fetched); berr != nil {
+		t.Fatalf("FetchEntity returned an error: %s", berr)
+	}
+	if fetched.Name != "world" {
+		t.Errorf("FetchEntity after SaveEntity returned name %q, expected %q", fetched.Name, "world")
+	}
+
+	child := &Object{Name: "child"}
+	if berr := s.CreateChild(o, child); berr != nil {
+		t.Fatalf("CreateChild returned an error: %s", berr)
+	}
+	if child.ParentID != o.ID || child.ParentType != ObjectIdentity.Name {
+		t.Errorf("CreateChild set parent %s/%s, expected %s/%s", child.ParentType, child.ParentID, ObjectIdentity.Name, o.ID)
+	}
+
+	if berr := s.DeleteEntity(o); berr != nil {
+		t.Fatalf("DeleteEntity returned an error: %s", berr)
+	}
+
+	checkNotFound(t, "FetchEntity after DeleteEntity", s.FetchEntity(NewObject(o.ID)))
+	checkNotFound(t, "FetchEntity of a child after DeleteEntity", s.FetchEntity(NewObject(child.ID)))
+}
+
+func testMissingObjects(t *testing.T, s bambou.Storer) {
+
+	var children ObjectsList
+
+	checkNotFound(t, "FetchEntity", s.FetchEntity(NewObject("missing")))
+	checkNotFound(t, "SaveEntity", s.SaveEntity(NewObject("missing")))
+	checkNotFound(t, "DeleteEntity", s.DeleteEntity(NewObject("missing")))
+	checkNotFound(t, "CreateChild", s.CreateChild(NewObject("missing"), &Object{}))
+	checkNotFound(t, "FetchChildren", s.FetchChildren(NewObject("missing"), ObjectIdentity, &children, nil))
+
+	if berr := s.FetchEntity(&Object{}); berr == nil {
+		t.Errorf("FetchEntity of an object without identifier should return an error")
+	}
+}
+
+func testPagination(t *testing.T, s bambou.Storer) {
+
+	parent := &Object{Name: "parent"}
+	if berr := s.CreateChild(s.Root(), parent); berr != nil {
+		t.Fatalf("CreateChild returned an error: %s", berr)
+	}
+
+	var created []string
+	for i := 0; i < 7; i++ {
+		o := &Object{Name: "child"}
+		if berr := s.CreateChild(parent, o); berr != nil {
+			t.Fatalf("CreateChild returned an error: %s", berr)
+		}
+		created = append(created, o.ID)
+	}
+
+	var fetched []string
+	for page, expected := range []int{3, 3, 1, 0} {
+
+		var children ObjectsList
+		info := bambou.NewFetchingInfo()
+		info.Page = page
+		info.PageSize = 3
+
+		if berr := s.FetchChildren(parent, ObjectIdentity, &children, info); berr != nil {
+			t.Fatalf("FetchChildren of page %d returned an error: %s", page, berr)
+		}
+		if len(children) != expected {
+			t.Errorf("FetchChildren of page %d returned %d children, expected %d", page, len(children), expected)
+		}
+		if info.TotalCount != len(created) {
+			t.Errorf("FetchChildren of page %d set TotalCount to %d, expected %d", page, info.TotalCount, len(created))
+		}
+
+		for _, c := range children {
+			fetched = append(fetched, c.ID)
+		}
+	}
+
+	checkIdentifiers(t, "FetchChildren", fetched, created)
+
+	var children ObjectsList
+	if berr := s.FetchChildren(s.Root(), ObjectIdentity, &children, nil); berr != nil {
+		t.Fatalf("FetchChildren of the root returned an error: %s", berr)
+	}
+	checkIdentifiers(t, "FetchChildren of the root", identifiers(children), []string{parent.ID})
+}
+
+func testAssignment(t *testing.T, s bambou.Storer) {
+
+	parent := &Object{Name: "parent"}
+	if berr := s.CreateChild(s.Root(), parent); berr != nil {
+		t.Fatalf("CreateChild returned an error: %s", berr)
+	}
+
+	var objects []bambou.Identifiable
+	for i := 0; i < 3; i++ {
+		o := &Object{Name: "member"}
+		if berr := s.CreateChild(s.Root(), o); berr != nil {
+			t.Fatalf("CreateChild returned an error: %s", berr)
+		}
+		objects = append(objects, o)
+	}
+
+	assign := func(assigned []bambou.Identifiable) {
+
+		if berr := s.AssignChildren(parent, assigned, ObjectIdentity); berr != nil {
+			t.Fatalf("AssignChildren returned an error: %s", berr)
+		}
+
+		var children ObjectsList
+		if berr := s.FetchChildren(parent, ObjectIdentity, &children, nil); berr != nil {
+			t.Fatalf("FetchChildren returned an error: %s", berr)
+		}
+
+		var expected []string
+		for _, o := range assigned {
+			expected = append(expected, o.Identifier())
+		}
+		checkIdentifiers(t, "FetchChildren after AssignChildren", identifiers(children), expected)
+	}
+
+	assign(objects[:2])
+	assign(objects[2:])
+	assign([]bambou.Identifiable{})
+
+	if berr := s.AssignChildren(parent, []bambou.Identifiable{NewObject("missing")}, ObjectIdentity); berr == nil {
+		t.Errorf("AssignChildren of a missing object should return an error")
+	}
+
+	if berr := s.AssignChildren(parent, []bambou.Identifiable{&Object{}}, ObjectIdentity); berr == nil {
+		t.Errorf("AssignChildren of an object without identifier should return an error")
+	}
+}
+
+func testEvents(t *testing.T, s bambou.Storer) {
+
+	channel := make(bambou.NotificationsChannel, 1)
+	if berr := s.NextEvent(channel, ""); berr != nil {
+		t.Fatalf("NextEvent returned an error: %s", berr)
+	}
+
+	var lastEventID string
+	select {
+	case notification := <-channel:
+		lastEventID = notification.UUID
+	default:
+		t.Fatalf("NextEvent without identifier did not send the current notification")
+	}
+
+	o := &Object{Name: "hello"}
+	if berr := s.CreateChild(s.Root(), o); berr != nil {
+		t.Fatalf("CreateChild returned an error: %s", berr)
+	}
+
+	lastEventID = waitForEvent(t, s, lastEventID, bambou.EventTypeCreate, o.ID)
+
+	if berr := s.DeleteEntity(o); berr != nil {
+		t.Fatalf("DeleteEntity returned an error: %s", berr)
+	}
+
+	waitForEvent(t, s, lastEventID, bambou.EventTypeDelete, o.ID)
+}
+
+// waitForEvent calls NextEvent until it receives an event of the given type for the
+// given object identifier. It returns the identifier of the last notification.
+// Before returning, it waits for the pending call to NextEvent to return.
+func waitForEvent(t *testing.T, s bambou.Storer, lastEventID string, eventType bambou.EventType, ID string) string {
+
+	found := make(chan string, 1)
+	failed := make(chan *bambou.Error, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	defer func() {
+		close(done)
+		select {
+		case <-stopped:
+		case <-time.After(EventTimeout):
+			t.Errorf("NextEvent did not return within %s", EventTimeout)
+		}
+	}()
+
+	go func() {
+		defer close(stopped)
+		for {
+			channel := make(bambou.NotificationsChannel, 1)
+			if berr := s.NextEvent(channel, lastEventID); berr != nil {
+				failed <- berr
+				return
+			}
+
+			select {
+			case notification := <-channel:
+				lastEventID = notification.UUID
+				for _, event := range notification.Events {
+					if event.Type == eventType && event.EntityType == ObjectIdentity.Name && len(event.DataMap) > 0 && event.DataMap[0]["ID"] == ID {
+						found <- notification.UUID
+						return
+					}
+				}
+			default:
+			}
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	select {
+	case UUID := <-found:
+		return UUID
+	case berr := <-failed:
+		t.Fatalf("NextEvent returned an error: %s", berr)
+	case <-time.After(EventTimeout):
+		t.Fatalf("Did not receive the %s event for %s", eventType, ID)
+	}
+
+	return ""
+}
+
+func checkNotFound(t *testing.T, operation string, berr *bambou.Error) {
+
+	if berr == nil {
+		t.Errorf("%s of a missing object should return an error", operation)
+		return
+	}
+
+	if berr.Code != http.StatusNotFound {
+		t.Errorf("%s of a missing object returned code %d, expected %d", operation, berr.Code, http.StatusNotFound)
+	}
+}
+
+func checkIdentifiers(t *testing.T, operation string, actual []string, expected []string) {
+
+	actual = append([]string{}, actual...)
+	expected = append([]string{}, expected...)
+	sort.Strings(actual)
+	sort.Strings(expected)
+
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("%s returned %v, expected %v", operation, actual, expected)
+	}
+}
+
+func identifiers(objects ObjectsList) []string {
+
+	var IDs []string
+	for _, o := range objects {
+		IDs = append(IDs, o.ID)
+	}
+
+	return IDs
+}
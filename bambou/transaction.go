@@ -0,0 +1,342 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// compensation represents the operation cancelling an operation of a Transaction.
+type compensation struct {
+	description string
+	undo        func() *Error
+}
+
+// Transaction runs operations on a Storer and records how to cancel each successful one.
+// If a step fails, Rollback cancels the recorded operations in reverse order:
+// created objects are deleted, saved objects are restored from the state fetched
+// before saving them, deleted objects are created again from the state fetched
+// before deleting them, and previous assignments are restored.
+//
+// Objects created again may get a new identifier from the server.
+type Transaction struct {
+	storer        Storer
+	compensations []*compensation
+	finished      bool
+	lock          sync.Mutex
+}
+
+// NewTransaction returns a new *Transaction running its operations on the given Storer.
+func NewTransaction(storer Storer) *Transaction {
+
+	return &Transaction{
+		storer: storer,
+	}
+}
+
+// BeginTransaction returns a new *Transaction running its operations on the Session.
+func (s *Session) BeginTransaction() *Transaction {
+
+	return NewTransaction(s)
+}
+
+// RunTransaction calls the given function with a new Transaction on the given Storer.
+// If the function returns an error, the Transaction is rolled back and the error is returned.
+// If the rollback fails too, the returned error describes both failures.
+func RunTransaction(storer Storer, fn func(*Transaction) *Error) *Error {
+
+	t := NewTransaction(storer)
+
+	berr := fn(t)
+	if berr == nil {
+		t.Commit()
+		return nil
+	}
+
+	if rerr := t.Rollback(); rerr != nil {
+		combined := *berr
+		combined.Description = fmt.Sprintf("%s (%s: %s)", berr.Description, rerr.Title, rerr.Description)
+		return &combined
+	}
+
+	return berr
+}
+
+// CreateChild creates the given child under the given parent.
+// Rolling back deletes the child.
+func (t *Transaction) CreateChild(parent Identifiable, child Identifiable) *Error {
+
+	return t.run(func() (*compensation, *Error) {
+
+		if berr := t.storer.CreateChild(parent, child); berr != nil {
+			return nil, berr
+		}
+
+		return &compensation{
+			description: "delete " + describeIdentifiable(child),
+			undo:        func() *Error { return t.storer.DeleteEntity(child) },
+		}, nil
+	})
+}
+
+// SaveEntity saves the given object.
+// Rolling back saves the state of the object fetched before saving it. If the object
+// has a lastUpdatedDate, rolling back returns a Conflict error instead when the object
+// has been modified on the server since the Transaction saved it.
+func (t *Transaction) SaveEntity(object Identifiable) *Error {
+
+	return t.run(func() (*compensation, *Error) {
+
+		snapshot, berr := t.snapshot(object)
+		if berr != nil {
+			return nil, berr
+		}
+
+		if berr := t.storer.SaveEntity(object); berr != nil {
+			return nil, berr
+		}
+
+		written, _ := lastUpdatedDate(object)
+
+		return &compensation{
+			description: "restore " + describeIdentifiable(object),
+			undo:        func() *Error { return t.restore(snapshot, written) },
+		}, nil
+	})
+}
+
+// DeleteChild deletes the given child of the given parent.
+// Rolling back creates the child again under the parent, from the state
+// fetched before deleting it. The children of the deleted object are not restored.
+func (t *Transaction) DeleteChild(parent Identifiable, child Identifiable) *Error {
+
+	return t.run(func() (*compensation, *Error) {
+
+		snapshot, berr := t.snapshot(child)
+		if berr != nil {
+			return nil, berr
+		}
+
+		if berr := t.storer.DeleteEntity(child); berr != nil {
+			return nil, berr
+		}
+
+		return &compensation{
+			description: "create " + describeIdentifiable(child),
+			undo:        func() *Error { return t.storer.CreateChild(parent, snapshot) },
+		}, nil
+	})
+}
+
+// AssignChildren assigns the given children with the given Identity to the given parent.
+// Rolling back assigns the children that were assigned before.
+func (t *Transaction) AssignChildren(parent Identifiable, children []Identifiable, identity Identity) *Error {
+
+	return t.run(func() (*compensation, *Error) {
+
+		previous, berr := fetchAllIdentifiers(t.storer, parent, identity)
+		if berr != nil {
+			return nil, berr
+		}
+
+		if berr := t.storer.AssignChildren(parent, children, identity); berr != nil {
+			return nil, berr
+		}
+
+		return &compensation{
+			description: fmt.Sprintf("restore %s assigned to %s", identity.Category, describeIdentifiable(parent)),
+			undo: func() *Error {
+				return t.storer.AssignChildren(parent, newIdentifiableReferences(identity, previous), identity)
+			},
+		}, nil
+	})
+}
+
+// Len returns the number of operations that would be cancelled by a rollback.
+func (t *Transaction) Len() int {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return len(t.compensations)
+}
+
+// Commit finishes the Transaction, keeping all its operations.
+func (t *Transaction) Commit() *Error {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.finished {
+		return newTransactionFinishedError()
+	}
+
+	t.finished = true
+	t.compensations = nil
+
+	return nil
+}
+
+// Rollback finishes the Transaction, cancelling all its operations in reverse order.
+// All the operations are cancelled even if some fail. The returned error
+// describes the operations that could not be cancelled. If some could not be cancelled
+// because of a Conflict, the returned error describes the first one.
+func (t *Transaction) Rollback() *Error {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.finished {
+		return newTransactionFinishedError()
+	}
+
+	t.finished = true
+
+	var failures []string
+	var conflict *Error
+	for i := len(t.compensations) - 1; i >= 0; i-- {
+		c := t.compensations[i]
+		if berr := c.undo(); berr != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", c.description, berr.Description))
+			if conflict == nil && berr.IsConflict() {
+				conflict = berr
+			}
+		}
+	}
+
+	t.compensations = nil
+
+	if len(failures) > 0 {
+		berr := NewBambouError("Rollback error", fmt.Sprintf("%d operations could not be cancelled: %s", len(failures), strings.Join(failures, "; ")))
+		if conflict != nil {
+			berr.Code = conflict.Code
+			berr.Conflict = conflict.Conflict
+		}
+		return berr
+	}
+
+	return nil
+}
+
+// Abort finishes the Transaction, cancelling all its operations. It is an alias of Rollback.
+func (t *Transaction) Abort() *Error {
+
+	return t.Rollback()
+}
+
+// run runs the given operation and records its compensation.
+func (t *Transaction) run(operation func() (*compensation, *Error)) *Error {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.finished {
+		return newTransactionFinishedError()
+	}
+
+	c, berr := operation()
+	if berr != nil {
+		return berr
+	}
+
+	t.compensations = append(t.compensations, c)
+
+	return nil
+}
+
+// snapshot fetches the current state of the given object into a new instance of its type.
+func (t *Transaction) snapshot(object Identifiable) (Identifiable, *Error) {
+
+	value := reflect.ValueOf(object)
+	if value.Kind() != reflect.Ptr {
+		return nil, NewBambouError("Transaction error", fmt.Sprintf("Cannot snapshot %s: it is not a pointer", describeIdentifiable(object)))
+	}
+
+	snapshot := reflect.New(value.Elem().Type()).Interface().(Identifiable)
+	snapshot.SetIdentifier(object.Identifier())
+
+	if berr := t.storer.FetchEntity(snapshot); berr != nil {
+		return nil, berr
+	}
+
+	return snapshot, nil
+}
+
+// restore saves the given snapshot. If the Transaction wrote the given lastUpdatedDate,
+// the current version of the object is fetched first: a Conflict error is returned if it has
+// been modified since, otherwise the snapshot is saved with that lastUpdatedDate.
+func (t *Transaction) restore(snapshot Identifiable, written string) *Error {
+
+	if written == "" {
+		return t.storer.SaveEntity(snapshot)
+	}
+
+	current, berr := t.snapshot(snapshot)
+	if berr != nil {
+		return berr
+	}
+
+	if date, _ := lastUpdatedDate(current); date != written {
+		return NewConflictError(snapshot, current)
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return NewBambouError("JSON error", err.Error())
+	}
+
+	var version struct {
+		LastUpdatedDate json.RawMessage `json:"lastUpdatedDate"`
+	}
+
+	if err := json.Unmarshal(data, &version); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	if data, err = json.Marshal(version); err != nil {
+		return NewBambouError("JSON error", err.Error())
+	}
+
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	return t.storer.SaveEntity(snapshot)
+}
+
+// newTransactionFinishedError returns the error returned when using a finished Transaction.
+func newTransactionFinishedError() *Error {
+
+	return NewBambouError("Transaction error", "The transaction is already committed or rolled back")
+}
+
+// describeIdentifiable returns a short description of the given Identifiable.
+func describeIdentifiable(object Identifiable) string {
+
+	return object.Identity().Name + " " + object.Identifier()
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransaction_Rollback(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer with some objects", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		parent := NewFakeObject("parent")
+		m.CreateChild(r, parent)
+
+		saved := NewFakeObject("saved")
+		saved.Name = "before"
+		m.CreateChild(r, saved)
+
+		deleted := NewFakeObject("deleted")
+		deleted.Name = "deleted"
+		m.CreateChild(r, deleted)
+
+		m.CreateChild(r, NewFakeObject("a1"))
+		m.CreateChild(r, NewFakeObject("a2"))
+		m.AssignChildren(parent, []Identifiable{NewFakeObject("a1")}, FakeIdentity)
+
+		tr := NewTransaction(m)
+
+		Convey("When I run operations of each kind", func() {
+
+			created := NewFakeObject("created")
+			err1 := tr.CreateChild(r, created)
+
+			saved.Name = "after"
+			err2 := tr.SaveEntity(saved)
+
+			err3 := tr.DeleteChild(r, deleted)
+			err4 := tr.AssignChildren(parent, []Identifiable{NewFakeObject("a2")}, FakeIdentity)
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(err3, ShouldBeNil)
+				So(err4, ShouldBeNil)
+				So(tr.Len(), ShouldEqual, 4)
+			})
+
+			Convey("When I roll back the transaction", func() {
+
+				err := tr.Rollback()
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the created object should be deleted", func() {
+					So(m.FetchEntity(NewFakeObject("created")), ShouldNotBeNil)
+				})
+
+				Convey("Then the saved object should be restored", func() {
+					o := NewFakeObject("saved")
+					m.FetchEntity(o)
+					So(o.Name, ShouldEqual, "before")
+				})
+
+				Convey("Then the deleted object should be created again", func() {
+					o := NewFakeObject("deleted")
+					So(m.FetchEntity(o), ShouldBeNil)
+					So(o.Name, ShouldEqual, "deleted")
+				})
+
+				Convey("Then the previous assignment should be restored", func() {
+					var l FakeObjectsList
+					m.FetchChildren(parent, FakeIdentity, &l, nil)
+					So(len(l), ShouldEqual, 1)
+					So(l[0].ID, ShouldEqual, "a1")
+				})
+
+				Convey("Then the transaction should not be usable anymore", func() {
+					So(tr.CreateChild(r, NewFakeObject("other")), ShouldNotBeNil)
+					So(tr.Rollback(), ShouldNotBeNil)
+					So(tr.Commit(), ShouldNotBeNil)
+				})
+			})
+
+			Convey("When I commit the transaction", func() {
+
+				err := tr.Commit()
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the changes should be kept", func() {
+					So(m.FetchEntity(NewFakeObject("created")), ShouldBeNil)
+					So(m.FetchEntity(NewFakeObject("deleted")), ShouldNotBeNil)
+				})
+
+				Convey("Then the transaction should not be usable anymore", func() {
+					So(tr.Abort(), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When I save an object that does not exist", func() {
+
+			err := tr.SaveEntity(NewFakeObject("nope"))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then no operation should be recorded", func() {
+				So(tr.Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an object to cancel has been deleted by someone else", func() {
+
+			created := NewFakeObject("created")
+			tr.CreateChild(r, created)
+			m.DeleteEntity(created)
+
+			err := tr.Rollback()
+
+			Convey("Then the rollback error should describe the failure", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Rollback error")
+				So(err.Description, ShouldContainSubstring, "delete fake created")
+			})
+		})
+	})
+}
+
+func TestTransaction_RunTransaction(t *testing.T) {
+
+	Convey("Given I have a MemoryStorer", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+
+		Convey("When I run a transaction failing at the second step", func() {
+
+			err := RunTransaction(m, func(tr *Transaction) *Error {
+				if berr := tr.CreateChild(r, NewFakeObject("1")); berr != nil {
+					return berr
+				}
+				return tr.CreateChild(r, NewFakeObject("1"))
+			})
+
+			Convey("Then the error of the step should be returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, 409)
+			})
+
+			Convey("Then the first step should be cancelled", func() {
+				So(m.FetchEntity(NewFakeObject("1")), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I run a successful transaction", func() {
+
+			err := RunTransaction(m, func(tr *Transaction) *Error {
+				return tr.CreateChild(r, NewFakeObject("1"))
+			})
+
+			Convey("Then err should be nil and the object created", func() {
+				So(err, ShouldBeNil)
+				So(m.FetchEntity(NewFakeObject("1")), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestTransaction_RollbackWithOptimisticConcurrency(t *testing.T) {
+
+	Convey("Given I have a server updating the lastUpdatedDate and a session with optimistic concurrency", t, func() {
+
+		var lock sync.Mutex
+		name := "before"
+		date := 1000
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if r.Method == "PUT" {
+				o := &datedFakeObject{}
+				json.NewDecoder(r.Body).Decode(o)
+				name = o.Name
+				date++
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"ID": "xxx", "name": "%s", "lastUpdatedDate": "%d"}]`, name, date)
+		}))
+		defer ts.Close()
+
+		session := NewSession("username", "password", "organization", ts.URL, NewFakeRootObject())
+		session.OptimisticConcurrency = true
+
+		Convey("When I save an object in a transaction and roll it back", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx"}}
+			session.FetchEntity(o)
+
+			tr := session.BeginTransaction()
+			o.Name = "changed"
+			err1 := tr.SaveEntity(o)
+			err2 := tr.Rollback()
+
+			Convey("Then the errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the object should be restored", func() {
+				So(name, ShouldEqual, "before")
+			})
+		})
+
+		Convey("When I save an object in a transaction, another writer saves it and I roll it back", func() {
+
+			o := &datedFakeObject{FakeObject: FakeObject{ID: "xxx"}}
+			session.FetchEntity(o)
+
+			tr := session.BeginTransaction()
+			o.Name = "changed"
+			err1 := tr.SaveEntity(o)
+
+			lock.Lock()
+			name = "other"
+			date++
+			lock.Unlock()
+
+			err2 := tr.Rollback()
+
+			Convey("Then the first error should be nil", func() {
+				So(err1, ShouldBeNil)
+			})
+
+			Convey("Then the rollback should return a conflict with the server version", func() {
+				So(err2, ShouldNotBeNil)
+				So(err2.IsConflict(), ShouldBeTrue)
+				So(err2.Conflict.Server.(*datedFakeObject).Name, ShouldEqual, "other")
+			})
+
+			Convey("Then the changes of the other writer should be kept", func() {
+				So(name, ShouldEqual, "other")
+			})
+		})
+	})
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TreeChangeType represents the type of a TreeChange.
+type TreeChangeType string
+
+// Supported TreeChangeTypes.
+const (
+	TreeChangeAdded   TreeChangeType = "added"
+	TreeChangeRemoved TreeChangeType = "removed"
+	TreeChangeChanged TreeChangeType = "changed"
+)
+
+// AttributeChange represents an attribute with different values in two trees.
+// A missing attribute has a nil value.
+type AttributeChange struct {
+	Name string      `json:"name"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// TreeChange represents an object that differs between two trees. The Path contains
+// the Identity name and the natural key of the object and of its ancestors.
+// Only the topmost object of an added or removed subtree is reported.
+type TreeChange struct {
+	Type       TreeChangeType     `json:"type"`
+	Identity   string             `json:"identity"`
+	Path       string             `json:"path"`
+	Attributes []*AttributeChange `json:"attributes,omitempty"`
+}
+
+// String returns the string representation of the TreeChange.
+func (c *TreeChange) String() string {
+
+	switch c.Type {
+
+	case TreeChangeAdded:
+		return "+ " + c.Path
+
+	case TreeChangeRemoved:
+		return "- " + c.Path
+
+	default:
+		lines := make([]string, len(c.Attributes)+1)
+		lines[0] = "~ " + c.Path
+		for i, attribute := range c.Attributes {
+			lines[i+1] = fmt.Sprintf("    %s: %s -> %s", attribute.Name, formatAttributeValue(attribute.Old), formatAttributeValue(attribute.New))
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+// TreeDiff represents the differences between two trees.
+type TreeDiff struct {
+	Changes []*TreeChange `json:"changes"`
+}
+
+// Empty returns true if the trees are the same.
+func (d *TreeDiff) Empty() bool {
+
+	return len(d.Changes) == 0
+}
+
+// String returns the human readable representation of the TreeDiff.
+func (d *TreeDiff) String() string {
+
+	lines := make([]string, len(d.Changes))
+	for i, change := range d.Changes {
+		lines[i] = change.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// TreeDiffer compares two trees of Identifiables, possibly fetched from different Storers.
+// The objects are matched by Identity and natural key, so their identifiers can differ.
+// The assignments are not compared, so the assigned children are never fetched.
+// The Identities of the descendants must be registered in the DefaultIdentityRegistry.
+type TreeDiffer struct {
+
+	// Children contains the Identities of the children to compare,
+	// indexed by the Name of the Identity of their parent. If nil, the owned
+	// children of the IdentitySchemas of the DefaultIdentityRegistry are compared.
+	Children map[string][]Identity
+
+	// KeyFunc returns the natural key of an Identifiable. By default, the name is used.
+	KeyFunc func(Identifiable) string
+
+	// IgnoredAttributes are the attributes that are not compared. By default, the identifiers,
+	// ownership and dates are ignored, as well as the read-only attributes of the IdentitySchemas
+	// of the DefaultIdentityRegistry.
+	IgnoredAttributes []string
+}
+
+// NewTreeDiffer returns a new *TreeDiffer comparing the given children Identities.
+func NewTreeDiffer(children map[string][]Identity) *TreeDiffer {
+
+	return &TreeDiffer{
+		Children: children,
+	}
+}
+
+// Diff compares the given source root from the given source Storer with the given target root
+// from the given target Storer. The roots are always compared with each other, whatever their keys.
+func (d *TreeDiffer) Diff(ctx context.Context, source Storer, sourceRoot Identifiable, target Storer, targetRoot Identifiable) (*TreeDiff, *Error) {
+
+	from, berr := d.exporter(source).Export(ctx, sourceRoot)
+	if berr != nil {
+		return nil, berr
+	}
+
+	to, berr := d.exporter(target).Export(ctx, targetRoot)
+	if berr != nil {
+		return nil, berr
+	}
+
+	diff := &TreeDiff{Changes: []*TreeChange{}}
+
+	key, berr := d.key(to)
+	if berr != nil {
+		return nil, berr
+	}
+
+	if berr := d.compare(diff, describeKey(to.Identity, key), from, to); berr != nil {
+		return nil, berr
+	}
+
+	return diff, nil
+}
+
+// exporter returns an Exporter of the compared children from the given Storer, without assignments.
+func (d *TreeDiffer) exporter(storer Storer) *Exporter {
+
+	exporter := NewExporter(storer, d.Children)
+	exporter.Assignments = map[string][]Identity{}
+
+	return exporter
+}
+
+// compare adds the differences between the given matching ExportedObjects at the given path to the given TreeDiff.
+func (d *TreeDiffer) compare(diff *TreeDiff, path string, from *ExportedObject, to *ExportedObject) *Error {
+
+	if attributes := d.compareAttributes(to.Identity, from.Attributes, to.Attributes); len(attributes) > 0 {
+		diff.Changes = append(diff.Changes, &TreeChange{
+			Type:       TreeChangeChanged,
+			Identity:   to.Identity,
+			Path:       path,
+			Attributes: attributes,
+		})
+	}
+
+	candidates := map[string][]*ExportedObject{}
+	for _, child := range from.Children {
+
+		key, berr := d.key(child)
+		if berr != nil {
+			return berr
+		}
+
+		id := child.Identity + "/" + key
+		candidates[id] = append(candidates[id], child)
+	}
+
+	matched := map[*ExportedObject]bool{}
+
+	for _, child := range to.Children {
+
+		key, berr := d.key(child)
+		if berr != nil {
+			return berr
+		}
+
+		childPath := path + "/" + describeKey(child.Identity, key)
+
+		id := child.Identity + "/" + key
+		if len(candidates[id]) == 0 {
+			diff.Changes = append(diff.Changes, &TreeChange{
+				Type:     TreeChangeAdded,
+				Identity: child.Identity,
+				Path:     childPath,
+			})
+			continue
+		}
+
+		match := candidates[id][0]
+		candidates[id] = candidates[id][1:]
+		matched[match] = true
+
+		if berr := d.compare(diff, childPath, match, child); berr != nil {
+			return berr
+		}
+	}
+
+	for _, child := range from.Children {
+
+		if matched[child] {
+			continue
+		}
+
+		key, berr := d.key(child)
+		if berr != nil {
+			return berr
+		}
+
+		diff.Changes = append(diff.Changes, &TreeChange{
+			Type:     TreeChangeRemoved,
+			Identity: child.Identity,
+			Path:     path + "/" + describeKey(child.Identity, key),
+		})
+	}
+
+	return nil
+}
+
+// compareAttributes returns the changes between the given attributes of objects
+// with the given Identity name, sorted by name.
+func (d *TreeDiffer) compareAttributes(identity string, from map[string]interface{}, to map[string]interface{}) []*AttributeChange {
+
+	ignored := d.IgnoredAttributes
+	if ignored == nil {
+		ignored = readOnlyAttributesOf(identity)
+	}
+
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	for _, name := range ignored {
+		delete(names, name)
+	}
+
+	var changes []*AttributeChange
+	for name := range names {
+
+		if !reflect.DeepEqual(from[name], to[name]) {
+			changes = append(changes, &AttributeChange{
+				Name: name,
+				Old:  from[name],
+				New:  to[name],
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+
+	return changes
+}
+
+// key returns the natural key of the given ExportedObject.
+func (d *TreeDiffer) key(exported *ExportedObject) (string, *Error) {
+
+	object := DefaultIdentityRegistry.NewFromName(exported.Identity)
+	if object == nil {
+		return "", NewBambouError("Diff error", fmt.Sprintf("No registered identity named %s", exported.Identity))
+	}
+
+	data, err := json.Marshal(exported.Attributes)
+	if err != nil {
+		return "", NewBambouError("JSON error", err.Error())
+	}
+
+	if err := json.Unmarshal(data, object); err != nil {
+		return "", NewBambouError("JSON unmarshalling error", err.Error())
+	}
+
+	object.SetIdentifier(exported.ID)
+
+	return naturalKey(object, d.KeyFunc), nil
+}
+
+// describeKey returns a short description of an object with the given Identity name and natural key.
+func describeKey(identity string, key string) string {
+
+	return fmt.Sprintf("%s %q", identity, key)
+}
+
+// formatAttributeValue returns the JSON representation of the given attribute value.
+func formatAttributeValue(value interface{}) string {
+
+	if value == nil {
+		return "<none>"
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTreeDiffer_Diff(t *testing.T) {
+
+	Convey("Given I have the same tree in two storers", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		RegisterIdentity(thingIdentity, func() Identifiable { return &thing{} })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+		defer DefaultIdentityRegistry.Unregister(thingIdentity)
+
+		r1 := NewFakeRootObject()
+		m1 := NewMemoryStorer(r1)
+		a1 := &FakeObject{ID: "a1", Name: "alpha"}
+		m1.CreateChild(r1, a1)
+		m1.CreateChild(a1, &thing{Name: "one", Description: "first"})
+		m1.CreateChild(a1, &thing{Name: "two"})
+
+		r2 := NewFakeRootObject()
+		m2 := NewMemoryStorer(r2)
+		a2 := &FakeObject{ID: "a2", Name: "alpha"}
+		m2.CreateChild(r2, a2)
+		m2.CreateChild(a2, &thing{Name: "one", Description: "first"})
+		m2.CreateChild(a2, &thing{Name: "two"})
+
+		d := NewTreeDiffer(map[string][]Identity{FakeIdentity.Name: {thingIdentity}})
+
+		Convey("When I compare them", func() {
+
+			diff, err := d.Diff(context.Background(), m1, a1, m2, a2)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the diff should be empty", func() {
+				So(diff.Empty(), ShouldBeTrue)
+				So(diff.String(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a member schema is registered and an assigned object has no name", func() {
+
+			RegisterIdentitySchema(FakeIdentity, &IdentitySchema{Children: []Relationship{{Identity: FakeIdentity, Member: true}}})
+
+			nameless := &FakeObject{}
+			m1.CreateChild(r1, nameless)
+			m1.AssignChildren(a1, []Identifiable{nameless}, FakeIdentity)
+
+			diff, err := d.Diff(context.Background(), m1, a1, m2, a2)
+
+			Convey("Then the assignments should be ignored", func() {
+				So(err, ShouldBeNil)
+				So(diff.Empty(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I modify the target tree and compare them", func() {
+
+			var things []*thing
+			m2.FetchChildren(a2, thingIdentity, &things, nil)
+			things[0].Description = "second"
+			m2.SaveEntity(things[0])
+			m2.DeleteEntity(things[1])
+			m2.CreateChild(a2, &thing{Name: "three"})
+			m2.CreateChild(a2, &thing{Name: "four"})
+
+			diff, err := d.Diff(context.Background(), m1, a1, m2, a2)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the changes should be reported", func() {
+				So(diff.Empty(), ShouldBeFalse)
+				So(len(diff.Changes), ShouldEqual, 4)
+				So(diff.Changes[0], ShouldResemble, &TreeChange{
+					Type:       TreeChangeChanged,
+					Identity:   "thing",
+					Path:       `fake "alpha"/thing "one"`,
+					Attributes: []*AttributeChange{{Name: "description", Old: "first", New: "second"}},
+				})
+			})
+
+			Convey("Then the human readable form should describe the changes", func() {
+				So(diff.String(), ShouldEqual, `~ fake "alpha"/thing "one"
+    description: "first" -> "second"
++ fake "alpha"/thing "three"
++ fake "alpha"/thing "four"
+- fake "alpha"/thing "two"`)
+			})
+
+			Convey("Then the JSON form should describe the changes", func() {
+				data, _ := json.Marshal(diff.Changes[1])
+				So(string(data), ShouldEqual, `{"type":"added","identity":"thing","path":"fake \"alpha\"/thing \"three\""}`)
+			})
+
+			Convey("When the changed attribute is read-only in the registered schema", func() {
+
+				RegisterIdentitySchema(thingIdentity, &IdentitySchema{Attributes: []AttributeSchema{{Name: "description", ReadOnly: true}}})
+				defer DefaultIdentityRegistry.Unregister(thingIdentity)
+
+				diff, _ := d.Diff(context.Background(), m1, a1, m2, a2)
+
+				Convey("Then the attribute change should not be reported", func() {
+					So(len(diff.Changes), ShouldEqual, 3)
+				})
+			})
+
+			Convey("When I ignore the changed attribute", func() {
+
+				d.IgnoredAttributes = []string{"description"}
+				diff, _ := d.Diff(context.Background(), m1, a1, m2, a2)
+
+				Convey("Then the attribute change should not be reported", func() {
+					So(len(diff.Changes), ShouldEqual, 3)
+				})
+			})
+		})
+
+		Convey("When I rename the target root and compare them", func() {
+
+			a2.Name = "beta"
+			m2.SaveEntity(a2)
+
+			diff, _ := d.Diff(context.Background(), m1, a1, m2, a2)
+
+			Convey("Then the roots should still be compared", func() {
+				So(diff.String(), ShouldEqual, `~ fake "beta"
+    name: "alpha" -> "beta"`)
+			})
+		})
+
+		Convey("When I compare them with another key", func() {
+
+			d.KeyFunc = func(object Identifiable) string {
+				if o, ok := object.(*thing); ok {
+					return o.Description
+				}
+				return object.Identifier()
+			}
+
+			diff, err := d.Diff(context.Background(), m1, a1, m2, a2)
+
+			Convey("Then the objects should be matched with the key", func() {
+				So(err, ShouldBeNil)
+				So(diff.Empty(), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"sync"
+)
+
+// SkipSubtree can be returned by a WalkFunc to prevent the Walker from walking
+// the descendants of the visited Identifiable. It is not returned by Walk.
+var SkipSubtree = NewBambouError("Skip subtree", "The descendants of the visited object must not be walked")
+
+// WalkOrder represents the order in which a Walker visits the Identifiables.
+type WalkOrder int
+
+// Supported WalkOrders.
+const (
+	// DepthFirst visits each Identifiable before its descendants,
+	// and the descendants of an Identifiable before its next sibling.
+	DepthFirst WalkOrder = iota
+
+	// BreadthFirst visits all the Identifiables of a depth before
+	// the ones of the next depth.
+	BreadthFirst
+)
+
+// WalkNode represents an Identifiable visited by a Walker.
+type WalkNode struct {
+	Object Identifiable
+	Parent *WalkNode
+	Depth  int
+}
+
+// WalkFunc is the prototype of the function called by a Walker for each visited Identifiable.
+// Returning SkipSubtree prevents the walking of its descendants. Returning another error stops the walk.
+type WalkFunc func(ctx context.Context, node *WalkNode) *Error
+
+// Walker walks the descendants of an Identifiable using FetchAllChildren, so the
+// Identities of the descendants must be registered in the DefaultIdentityRegistry.
+type Walker struct {
+
+	// Children contains the Identities of the children to walk,
+	// indexed by the Name of the Identity of their parent. If nil, the owned
+	// children of the IdentitySchemas of the DefaultIdentityRegistry are walked.
+	Children map[string][]Identity
+
+	// Order is the order of the visits. Default is DepthFirst.
+	Order WalkOrder
+
+	// Concurrency is the maximum number of goroutines walking the tree, including
+	// the one calling Walk, and so the maximum number of concurrent fetches. Default is 1.
+	// In DepthFirst order, the subtrees of siblings are walked concurrently
+	// when Concurrency is greater than 1, so the WalkFunc can be called up to
+	// Concurrency times concurrently and the order of the visits is only kept
+	// within each subtree. In BreadthFirst order, the WalkFunc is never called concurrently.
+	Concurrency int
+
+	// MaxDepth is the depth of the deepest Identifiables to visit.
+	// The walked Identifiable has the depth 0. Default is 0, meaning unlimited.
+	MaxDepth int
+
+	// Filter, if set, is called before fetching the children with the given Identity
+	// of the given parent. The children are not walked if it returns false.
+	Filter func(parent Identifiable, identity Identity) bool
+
+	storer Storer
+}
+
+// NewWalker returns a new *Walker walking the given children Identities with the given Storer.
+func NewWalker(storer Storer, children map[string][]Identity) *Walker {
+
+	return &Walker{
+		Children:    children,
+		Concurrency: 1,
+		storer:      storer,
+	}
+}
+
+// Walk visits the given root and its descendants with the given WalkFunc.
+// It returns the first error returned by the WalkFunc or the Storer,
+// or an error if the given context is done before the end of the walk.
+func (w *Walker) Walk(ctx context.Context, root Identifiable, visitor WalkFunc) *Error {
+
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	children := w.Children
+	if children == nil {
+		children = DefaultIdentityRegistry.ChildrenIdentities()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	walk := &walk{
+		walker:     w,
+		identities: children,
+		visitor:    visitor,
+		cancel:     cancel,
+		slots:      make(chan struct{}, concurrency),
+		workers:    make(chan struct{}, concurrency-1),
+	}
+
+	node := &WalkNode{Object: root}
+
+	if w.Order == BreadthFirst {
+		walk.breadthFirst(ctx, []*WalkNode{node})
+	} else {
+		walk.depthFirst(ctx, node)
+	}
+
+	return walk.result(ctx)
+}
+
+// walk contains the state of a call to Walk.
+type walk struct {
+	walker     *Walker
+	identities map[string][]Identity
+	visitor    WalkFunc
+	cancel     context.CancelFunc
+	slots      chan struct{}
+	workers    chan struct{}
+	err        *Error
+	lock       sync.Mutex
+}
+
+// depthFirst visits the given node and walks its children subtrees.
+func (w *walk) depthFirst(ctx context.Context, node *WalkNode) {
+
+	if !w.visit(ctx, node) {
+		return
+	}
+
+	children := w.children(ctx, node)
+
+	// A child subtree is walked by a new goroutine if a worker is available,
+	// or by this one otherwise, so there are never more than Concurrency goroutines
+	var wg sync.WaitGroup
+
+	for _, child := range children {
+
+		select {
+		case w.workers <- struct{}{}:
+			wg.Add(1)
+			go func(child *WalkNode) {
+				defer wg.Done()
+				defer func() { <-w.workers }()
+				w.depthFirst(ctx, child)
+			}(child)
+
+		default:
+			w.depthFirst(ctx, child)
+		}
+	}
+
+	wg.Wait()
+}
+
+// breadthFirst visits the given nodes and walks their children, depth by depth.
+func (w *walk) breadthFirst(ctx context.Context, nodes []*WalkNode) {
+
+	for len(nodes) > 0 {
+
+		var expanded []*WalkNode
+		for _, node := range nodes {
+			if w.visit(ctx, node) {
+				expanded = append(expanded, node)
+			}
+		}
+
+		children := make([][]*WalkNode, len(expanded))
+
+		workers := cap(w.slots)
+		if workers > len(expanded) {
+			workers = len(expanded)
+		}
+
+		indexes := make(chan int, len(expanded))
+		for i := range expanded {
+			indexes <- i
+		}
+		close(indexes)
+
+		var wg sync.WaitGroup
+		wg.Add(workers)
+
+		for n := 0; n < workers; n++ {
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					children[i] = w.children(ctx, expanded[i])
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		nodes = nil
+		for _, c := range children {
+			nodes = append(nodes, c...)
+		}
+	}
+}
+
+// visit calls the WalkFunc for the given node and returns true if its children must be walked.
+func (w *walk) visit(ctx context.Context, node *WalkNode) bool {
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if berr := w.visitor(ctx, node); berr != nil {
+		if berr != SkipSubtree {
+			w.fail(berr)
+		}
+		return false
+	}
+
+	return w.walker.MaxDepth <= 0 || node.Depth < w.walker.MaxDepth
+}
+
+// children fetches the children of the given node.
+func (w *walk) children(ctx context.Context, node *WalkNode) []*WalkNode {
+
+	var nodes []*WalkNode
+
+	for _, identity := range w.identities[node.Object.Identity().Name] {
+
+		if w.walker.Filter != nil && !w.walker.Filter(node.Object, identity) {
+			continue
+		}
+
+		select {
+		case w.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		children, berr := FetchAllChildren(w.walker.storer, node.Object, identity)
+		<-w.slots
+
+		if berr != nil {
+			w.fail(berr)
+			return nil
+		}
+
+		for _, child := range children {
+			nodes = append(nodes, &WalkNode{
+				Object: child,
+				Parent: node,
+				Depth:  node.Depth + 1,
+			})
+		}
+	}
+
+	return nodes
+}
+
+// fail stops the walk with the given error, unless it is already stopped.
+func (w *walk) fail(berr *Error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.err == nil {
+		w.err = berr
+		w.cancel()
+	}
+}
+
+// result returns the error stopping the walk, if any.
+func (w *walk) result(ctx context.Context) *Error {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return NewBambouError("Walk error", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newWalkedTree returns a MemoryStorer containing a tree of fakes and things.
+func newWalkedTree() (*FakeRootObject, *MemoryStorer) {
+
+	r := NewFakeRootObject()
+	m := NewMemoryStorer(r)
+
+	a := NewFakeObject("a")
+	b := NewFakeObject("b")
+	m.CreateChild(r, a)
+	m.CreateChild(r, b)
+	m.CreateChild(a, &thing{ID: "a1"})
+	m.CreateChild(a, &thing{ID: "a2"})
+	m.CreateChild(b, &thing{ID: "b1"})
+
+	return r, m
+}
+
+// walkChildren are the children walked in the tree returned by newWalkedTree.
+var walkChildren = map[string][]Identity{
+	FakeRootIdentity.Name: {FakeIdentity},
+	FakeIdentity.Name:     {thingIdentity},
+}
+
+// recordVisits returns a WalkFunc recording the identifiers of the visited Identifiables.
+func recordVisits(visits *[]string) WalkFunc {
+
+	var lock sync.Mutex
+
+	return func(ctx context.Context, node *WalkNode) *Error {
+		lock.Lock()
+		defer lock.Unlock()
+		*visits = append(*visits, node.Object.Identifier())
+		return nil
+	}
+}
+
+func TestWalker_Walk(t *testing.T) {
+
+	Convey("Given I have a tree of objects and a Walker", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		RegisterIdentity(thingIdentity, func() Identifiable { return &thing{} })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+		defer DefaultIdentityRegistry.Unregister(thingIdentity)
+
+		r, m := newWalkedTree()
+		r.ID = "root"
+		w := NewWalker(m, walkChildren)
+
+		var visits []string
+
+		Convey("When I walk the tree depth first", func() {
+
+			err := w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the objects should be visited depth first", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "a1", "a2", "b", "b1"})
+			})
+		})
+
+		Convey("When I walk the tree breadth first", func() {
+
+			w.Order = BreadthFirst
+			err := w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then the objects should be visited breadth first", func() {
+				So(err, ShouldBeNil)
+				So(visits, ShouldResemble, []string{"root", "a", "b", "a1", "a2", "b1"})
+			})
+		})
+
+		Convey("When I walk the tree depth first with concurrency", func() {
+
+			w.Concurrency = 4
+			err := w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then all the objects should be visited", func() {
+				So(err, ShouldBeNil)
+				sort.Strings(visits)
+				So(visits, ShouldResemble, []string{"a", "a1", "a2", "b", "b1", "root"})
+			})
+		})
+
+		Convey("When I walk a wide tree depth first with concurrency", func() {
+
+			for i := 0; i < 50; i++ {
+				m.CreateChild(r, NewFakeObject(fmt.Sprintf("wide%d", i)))
+			}
+
+			var lock sync.Mutex
+			var current, max, count int
+
+			w.Concurrency = 3
+			err := w.Walk(context.Background(), r, func(ctx context.Context, node *WalkNode) *Error {
+				lock.Lock()
+				current++
+				count++
+				if current > max {
+					max = current
+				}
+				lock.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				lock.Lock()
+				current--
+				lock.Unlock()
+				return nil
+			})
+
+			Convey("Then all the objects should be visited", func() {
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 56)
+			})
+
+			Convey("Then the visitor should not be called more concurrently than allowed", func() {
+				So(max, ShouldBeGreaterThan, 1)
+				So(max, ShouldBeLessThanOrEqualTo, 3)
+			})
+		})
+
+		Convey("When I walk the tree breadth first with concurrency", func() {
+
+			w.Order = BreadthFirst
+			w.Concurrency = 4
+			err := w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then the objects should be visited breadth first", func() {
+				So(err, ShouldBeNil)
+				So(visits, ShouldResemble, []string{"root", "a", "b", "a1", "a2", "b1"})
+			})
+		})
+
+		Convey("When I walk the tree with a max depth", func() {
+
+			w.MaxDepth = 1
+			w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then the deeper objects should not be visited", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "b"})
+			})
+		})
+
+		Convey("When I walk the tree with a filter", func() {
+
+			w.Filter = func(parent Identifiable, identity Identity) bool { return identity != thingIdentity }
+			w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then the filtered children should not be visited", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "b"})
+			})
+		})
+
+		Convey("When I skip a subtree", func() {
+
+			err := w.Walk(context.Background(), r, func(ctx context.Context, node *WalkNode) *Error {
+				visits = append(visits, node.Object.Identifier())
+				if node.Object.Identifier() == "a" {
+					return SkipSubtree
+				}
+				return nil
+			})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the subtree should not be visited", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "b", "b1"})
+			})
+		})
+
+		Convey("When the visitor returns an error", func() {
+
+			err := w.Walk(context.Background(), r, func(ctx context.Context, node *WalkNode) *Error {
+				visits = append(visits, node.Object.Identifier())
+				if node.Object.Identifier() == "a1" {
+					return NewBambouError("Visit error", "nope")
+				}
+				return nil
+			})
+
+			Convey("Then err should be the error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Visit error")
+			})
+
+			Convey("Then the walk should be stopped", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "a1"})
+			})
+		})
+
+		Convey("When I walk the tree with a canceled context", func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := w.Walk(ctx, r, recordVisits(&visits))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Title, ShouldEqual, "Walk error")
+				So(visits, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I walk children that are not registered", func() {
+
+			DefaultIdentityRegistry.Unregister(thingIdentity)
+			err := w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I walk the tree without children identities", func() {
+
+			RegisterIdentitySchema(FakeRootIdentity, &IdentitySchema{Children: []Relationship{{Identity: FakeIdentity}}})
+			defer DefaultIdentityRegistry.Unregister(FakeRootIdentity)
+
+			w.Children = nil
+			w.Walk(context.Background(), r, recordVisits(&visits))
+
+			Convey("Then the children of the registered schemas should be walked", func() {
+				So(visits, ShouldResemble, []string{"root", "a", "b"})
+			})
+		})
+
+		Convey("When I walk the parents of the visited objects", func() {
+
+			var paths []string
+			w.Walk(context.Background(), r, func(ctx context.Context, node *WalkNode) *Error {
+				path := ""
+				for n := node; n != nil; n = n.Parent {
+					path = "/" + n.Object.Identifier() + path
+				}
+				paths = append(paths, path)
+				return nil
+			})
+
+			Convey("Then the parents should be set", func() {
+				So(paths[2], ShouldEqual, "/root/a/a1")
+			})
+		})
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Change represents a change of an entity received by a PushCenter.
+type Change struct {
+	Type   EventType
+	Entity Identifiable
+	Event  *Event
+}
+
+// Watch returns a channel receiving the Changes of the children with the given Identity
+// of the given parent. If the parent is a Rootable, only the children without parent
+// are watched. If the parent is nil, all the entities with the given Identity are watched.
+// The entities are instantiated from the DefaultIdentityRegistry.
+//
+// The channel is closed when the given context is done. The Changes are queued until
+// they are received, so a slow receiver does not delay the PushCenter, but the queue
+// grows until the channel is drained.
+func (p *PushCenter) Watch(ctx context.Context, parent Identifiable, identity Identity) <-chan *Change {
+
+	filter := EventFilter{Identity: identity}
+
+	if _, ok := parent.(Rootable); ok {
+		filter.Predicate = func(e *Event) bool { return e.ParentID() == "" }
+	} else if parent != nil {
+		filter.ParentID = parent.Identifier()
+	}
+
+	return p.watch(ctx, filter, func() Identifiable {
+		return DefaultIdentityRegistry.New(identity)
+	})
+}
+
+// WatchEntity returns a channel receiving the Changes of the given entity.
+// The entities of the Changes are new instances of the type of the given entity.
+//
+// The channel is closed when the given context is done. The Changes are queued until
+// they are received, so a slow receiver does not delay the PushCenter, but the queue
+// grows until the channel is drained.
+func (p *PushCenter) WatchEntity(ctx context.Context, entity Identifiable) <-chan *Change {
+
+	filter := EventFilter{
+		Identity: entity.Identity(),
+		EntityID: entity.Identifier(),
+	}
+
+	entityType := reflect.TypeOf(entity).Elem()
+
+	return p.watch(ctx, filter, func() Identifiable {
+		return reflect.New(entityType).Interface().(Identifiable)
+	})
+}
+
+// watch returns a channel receiving the Changes matching the given EventFilter,
+// decoded in the Identifiables returned by the given factory. The handler only
+// queues the Changes, and a goroutine sends them to the channel.
+func (p *PushCenter) watch(ctx context.Context, filter EventFilter, factory IdentifiableFactory) <-chan *Change {
+
+	changes := make(chan *Change)
+	queued := make(chan struct{}, 1)
+
+	var lock sync.Mutex
+	var queue []*Change
+
+	subscription := p.subscribe(filter, func(event *Event) {
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		var received []*Change
+
+		for _, data := range event.DataMap {
+
+			entity := factory()
+			if entity == nil {
+				p.report(NewBambouError("Watch error", fmt.Sprintf("No registered identity named %s", event.EntityType)))
+				return
+			}
+
+			if berr := decodeEntity(data, entity); berr != nil {
+				p.report(berr)
+				continue
+			}
+
+			received = append(received, &Change{Type: event.Type, Entity: entity, Event: event})
+		}
+
+		if len(received) == 0 {
+			return
+		}
+
+		lock.Lock()
+		queue = append(queue, received...)
+		lock.Unlock()
+
+		select {
+		case queued <- struct{}{}:
+		default:
+		}
+	}, true)
+
+	go func() {
+
+		defer close(changes)
+		defer subscription.Cancel()
+
+		for {
+
+			lock.Lock()
+			pending := queue
+			queue = nil
+			lock.Unlock()
+
+			if len(pending) == 0 {
+				select {
+				case <-queued:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			for _, change := range pending {
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return changes
+}
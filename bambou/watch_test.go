@@ -0,0 +1,205 @@
+// Copyright (c) 2015, Alcatel-Lucent Inc.
+// All rights reserved.
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+// * Neither the name of bambou nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bambou
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// receiveChange returns the next Change of the given channel, or nil after a second.
+func receiveChange(changes <-chan *Change) *Change {
+
+	select {
+	case c := <-changes:
+		return c
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestWatch_Watch(t *testing.T) {
+
+	Convey("Given I have a started PushCenter on a MemoryStorer with two parents", t, func() {
+
+		RegisterIdentity(FakeIdentity, func() Identifiable { return NewFakeObject("") })
+		defer DefaultIdentityRegistry.Unregister(FakeIdentity)
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+
+		p1 := NewFakeObject("p1")
+		p2 := NewFakeObject("p2")
+		m.CreateChild(r, p1)
+		m.CreateChild(r, p2)
+
+		startPushCenter(p)
+		defer p.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("When I watch the children of the first parent and create children in both", func() {
+
+			changes := p.Watch(ctx, p1, FakeIdentity)
+
+			m.CreateChild(p2, NewFakeObject("c2"))
+			c1 := NewFakeObject("c1")
+			c1.Name = "hello"
+			m.CreateChild(p1, c1)
+
+			change := receiveChange(changes)
+
+			Convey("Then I should receive the creation of the child of the first parent", func() {
+				So(change, ShouldNotBeNil)
+				So(change.Type, ShouldEqual, EventTypeCreate)
+				So(change.Entity, ShouldHaveSameTypeAs, &FakeObject{})
+				So(change.Entity.Identifier(), ShouldEqual, "c1")
+				So(change.Entity.(*FakeObject).Name, ShouldEqual, "hello")
+			})
+
+			Convey("When I cancel the context", func() {
+
+				cancel()
+
+				_, open := <-changes
+				for open {
+					_, open = <-changes
+				}
+
+				Convey("Then the channel should be closed", func() {
+					So(open, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When a watcher does not receive its changes", func() {
+
+			p.Watch(ctx, p1, FakeIdentity)
+
+			other := p.Watch(ctx, p1, FakeIdentity)
+
+			for i := 0; i < 20; i++ {
+				m.CreateChild(p1, NewFakeObject(fmt.Sprintf("c%d", i)))
+			}
+
+			received := 0
+			for received < 20 && receiveChange(other) != nil {
+				received++
+			}
+
+			Convey("Then the other watchers should receive all the changes", func() {
+				So(received, ShouldEqual, 20)
+			})
+		})
+
+		Convey("When I unregister the handlers of the identity while watching", func() {
+
+			changes := p.Watch(ctx, p1, FakeIdentity)
+			handled := make(chan *Event, 1)
+			p.RegisterHandlerForIdentity(func(e *Event) { handled <- e }, FakeIdentity)
+
+			p.UnregisterHandlerForIdentity(FakeIdentity)
+			m.CreateChild(p1, NewFakeObject("c1"))
+
+			change := receiveChange(changes)
+
+			Convey("Then the watcher should still receive the changes", func() {
+				So(change, ShouldNotBeNil)
+				So(change.Entity.Identifier(), ShouldEqual, "c1")
+			})
+
+			Convey("Then the registered handler should not be called", func() {
+				So(len(handled), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I watch the children of the root and create children", func() {
+
+			changes := p.Watch(ctx, r, FakeIdentity)
+
+			m.CreateChild(p1, NewFakeObject("c1"))
+			m.CreateChild(r, NewFakeObject("c2"))
+
+			change := receiveChange(changes)
+
+			Convey("Then I should only receive the creation of the child of the root", func() {
+				So(change, ShouldNotBeNil)
+				So(change.Entity.Identifier(), ShouldEqual, "c2")
+			})
+		})
+	})
+}
+
+func TestWatch_WatchEntity(t *testing.T) {
+
+	Convey("Given I have a started PushCenter on a MemoryStorer with two objects", t, func() {
+
+		r := NewFakeRootObject()
+		m := NewMemoryStorer(r)
+		p := NewPushCenter(m)
+
+		o1 := NewFakeObject("o1")
+		o2 := NewFakeObject("o2")
+		m.CreateChild(r, o1)
+		m.CreateChild(r, o2)
+
+		startPushCenter(p)
+		defer p.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("When I watch the first object and update both", func() {
+
+			changes := p.WatchEntity(ctx, o1)
+
+			o2.Name = "other"
+			m.SaveEntity(o2)
+			o1.Name = "updated"
+			m.SaveEntity(o1)
+			m.DeleteEntity(o1)
+
+			update := receiveChange(changes)
+			deletion := receiveChange(changes)
+
+			Convey("Then I should receive the update of the first object", func() {
+				So(update, ShouldNotBeNil)
+				So(update.Type, ShouldEqual, EventTypeUpdate)
+				So(update.Entity, ShouldNotPointTo, o1)
+				So(update.Entity.(*FakeObject).Name, ShouldEqual, "updated")
+			})
+
+			Convey("Then I should receive the deletion of the first object", func() {
+				So(deletion, ShouldNotBeNil)
+				So(deletion.Type, ShouldEqual, EventTypeDelete)
+			})
+		})
+	})
+}